@@ -0,0 +1,44 @@
+package dam
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and waits for it, so that tests can replace the real
+// time with a fake one.
+type Clock interface {
+	Now() time.Time
+
+	// After is like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RealClock is the real time.
+var RealClock Clock = realClock{}
+
+// ClockOrReal returns clock or, if it is nil, RealClock.
+func ClockOrReal(clock Clock) Clock {
+	if clock == nil {
+		return RealClock
+	}
+	return clock
+}
+
+// Sleep waits on clock for d, or until ctx is done.
+func Sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
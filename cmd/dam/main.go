@@ -2,24 +2,360 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/grafov/m3u8"
+	"github.com/otommod/go-dam"
 	"github.com/otommod/go-dam/hls"
+	"github.com/otommod/go-dam/icy"
+	"github.com/otommod/go-dam/jobs"
+	"github.com/otommod/go-dam/metrics"
 )
 
 var (
-	format = flag.String("format", "best", "Which quality to download")
+	format = flag.String("format", "best", "Which quality to download (best, worst or a format ID)")
 	debug  = flag.Bool("debug", false, "Enable debugging messages")
+
+	fetchSessionData = flag.Bool("session-data", false, "Fetch session data given by URI")
+	fillGaps         = flag.Bool("fill-gaps", false, "Fill gaps with segments from other qualities")
+	start            = flag.String("start", "default", "Where to begin a live stream (default, live-edge or dvr)")
+	connections      = flag.Int("connections", 4, "How many connections to download plain files over")
+	restart          = flag.Bool("restart", false, "Start partially downloaded plain files over instead of resuming them")
+	split            = flag.Bool("split", false, "Record a file per stream title, and a cue sheet, in a directory")
+	concurrency      = flag.Int("concurrency", jobs.DefaultConcurrency, "How many jobs serve and batch run at once")
+	perHost          = flag.Int("per-host", 2, "How many downloads of a batch may be from the same host at once")
+	metricsAddr      = flag.String("metrics-addr", "", "Serve Prometheus metrics of HLS downloads at /metrics on this address")
+	window           = flag.Duration("window", 5*time.Minute, "How much of a relayed stream is kept for players to seek back in")
+	relayDir         = flag.String("relay-dir", "", "Keep the segments of a relayed stream in this directory instead of in memory")
 )
 
 func printUsageLine() {
+	name := flag.CommandLine.Name()
 	fmt.Fprintf(flag.CommandLine.Output(),
-		"Usage: %s [options] playlist-url output-file\n", flag.CommandLine.Name())
+		"Usage: %s [options] stream-url output-file|template|-\n"+
+			"       %s [options] mirror playlist-url directory\n"+
+			"       %s [options] record stream-url output-file|directory|-\n"+
+			"       %s [options] serve address directory\n"+
+			"       %s [options] batch list-file\n"+
+			"       %s [options] relay stream-url address\n", name, name, name, name, name, name)
+}
+
+func printSession(hlsClient hls.Client, master *hls.MasterPlaylist) {
+	for _, data := range master.SessionData {
+		value := strconv.Quote(data.Value)
+		if data.URI != "" {
+			value = data.URI
+			if *fetchSessionData {
+				// session data is optional, so the URI is printed instead
+				raw, err := hlsClient.ReadSessionData(context.TODO(), data)
+				if err != nil {
+					dam.Logger(hlsClient.Logger).Warn("cannot fetch session data", "id", data.DataID, "uri", data.URI, "err", err)
+				} else {
+					value = string(raw)
+				}
+			}
+		}
+
+		if data.Language != "" {
+			fmt.Fprintf(os.Stderr, "session-data %s [%s]: %s\n", data.DataID, data.Language, value)
+		} else {
+			fmt.Fprintf(os.Stderr, "session-data %s: %s\n", data.DataID, value)
+		}
+	}
+
+	for _, key := range master.SessionKeys {
+		fmt.Fprintf(os.Stderr, "session-key %s: %s\n", key.Method, key.URI)
+	}
+}
+
+// fallbackFormats lists the URIs of the formats other than selected, from the
+// highest bandwidth to the lowest, for filling gaps.
+func fallbackFormats(formats []dam.Format, selected dam.Format) []string {
+	others := make([]dam.Format, 0, len(formats))
+	for _, f := range formats {
+		if f.ID != selected.ID {
+			others = append(others, f)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Bandwidth > others[j].Bandwidth
+	})
+
+	uris := make([]string, 0, len(others))
+	for _, f := range others {
+		uris = append(uris, f.URI)
+	}
+	return uris
+}
+
+// stdout is where the output "-" is written.  It hides the io.WriterAt of
+// os.Stdout, which pipes do not support, so that plain files are written in
+// order.
+type stdout struct {
+	io.Writer
+}
+
+func (stdout) Close() error { return nil }
+
+// create creates the output file, or returns stdout if it is "-".  Writes to
+// stdout after its reader went away then fail with EPIPE, which downloads
+// stop at, instead of killing the program.
+func create(filename string) io.WriteCloser {
+	if filename == "-" {
+		signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+		return stdout{os.Stdout}
+	}
+
+	fd, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fd
+}
+
+func download(d dam.Downloader, uri string, format dam.Format, filename string) {
+	fd := create(filename)
+	defer fd.Close()
+
+	hlsClient, ok := d.(*hls.Client)
+	if !ok {
+		err := d.DownloadFormat(context.TODO(), uri, format, fd, nil)
+		if err != nil && !dam.IsClosedPipe(err) {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	report, err := hlsClient.DownloadReport(context.TODO(), format.URI, fd)
+	for _, gap := range report.Gaps {
+		if gap.FilledFrom != "" {
+			fmt.Fprintf(os.Stderr, "gap %d (%s): filled from %s\n", gap.SeqId, gap.Duration, gap.FilledFrom)
+		} else {
+			fmt.Fprintf(os.Stderr, "gap %d (%s): skipped\n", gap.SeqId, gap.Duration)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// expandOutput expands the template output for format of uri, and creates
+// the directories it names.
+func expandOutput(d dam.Downloader, uri string, format dam.Format, output string) string {
+	fields, err := dam.Describe(context.TODO(), d, uri, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name, err := dam.Template(output).Expand(fields)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filename := filepath.FromSlash(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Fatal(err)
+	}
+	return filename
+}
+
+// newLogger logs warnings, and with -debug also the progress of downloads, to
+// stderr.
+func newLogger() *slog.Logger {
+	level := slog.LevelWarn
+	if *debug {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// record records a radio stream until it is interrupted.
+func record(client *http.Client, uri, output string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := icy.Recorder{Client: client, Logger: newLogger()}
+	var err error
+	if *split {
+		err = r.RecordSplit(ctx, uri, output)
+	} else {
+		fd := create(output)
+		err = r.Record(ctx, uri, fd)
+		fd.Close()
+	}
+	if err != nil && ctx.Err() == nil && !dam.IsClosedPipe(err) {
+		log.Fatal(err)
+	}
+}
+
+// collector collects the metrics of HLS downloads, if -metrics-addr is given.
+var collector *metrics.Collector
+
+// newClient returns the HTTP client to download with, which also counts
+// responses if metrics are served.
+func newClient() *http.Client {
+	if *metricsAddr == "" {
+		return http.DefaultClient
+	}
+	if collector == nil {
+		collector = metrics.New()
+		serveMetrics(*metricsAddr, collector)
+	}
+	return &http.Client{Transport: collector.Transport(nil)}
+}
+
+// serveMetrics serves the metrics of collector on addr until the program
+// exits.
+func serveMetrics(addr string, collector *metrics.Collector) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector)
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
+// serve runs the job API on addr, downloading to dir.
+func serve(client *http.Client, addr, dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	m := &jobs.Manager{
+		Client:      client,
+		Dir:         dir,
+		Concurrency: *concurrency,
+		Logger:      newLogger(),
+	}
+	if collector != nil {
+		m.OnEvent = collector.Stream
+	}
+	if err := m.Load(); err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.ListenAndServe(addr, m.Handler()))
+}
+
+// relay downloads the stream of variant once and serves it again on addr,
+// until it is interrupted.
+func relay(hlsClient *hls.Client, uri string, variant *m3u8.Variant, addr string) {
+	if *relayDir != "" {
+		if err := os.MkdirAll(*relayDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	r := &hls.Relay{Window: *window, Dir: *relayDir, Variant: variant}
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, r.Handler()))
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	_, err := hlsClient.DownloadSink(ctx, uri, r)
+	r.Close()
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	// players may still be behind
+	fmt.Fprintln(os.Stderr, "the stream has ended; serving it until interrupted")
+	<-ctx.Done()
+}
+
+// errorReason tells why a download failed, by the type of err.
+func errorReason(err error) string {
+	var httpErr dam.HTTPError
+	var notSupported dam.NotSupportedError
+	switch {
+	case errors.As(err, &httpErr):
+		return "HTTP " + httpErr.Status
+	case errors.As(err, &notSupported):
+		return notSupported.Error()
+	case errors.Is(err, dam.ErrByterangeUnsupported):
+		return "byte ranges not supported"
+	case errors.Is(err, dam.ErrUnknownProtocol):
+		return "unknown protocol"
+	case errors.Is(err, hls.ErrPlaylistType), errors.Is(err, hls.ErrTargetDuration):
+		return "invalid playlist"
+	case errors.Is(err, hls.ErrSegmentsExpired):
+		return "segments expired"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "interrupted"
+	}
+	return "other error"
+}
+
+// batch downloads every entry of the list file, and prints a summary.
+func batch(client *http.Client, listFile string) {
+	fd, err := os.Open(listFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := jobs.ParseBatch(fd)
+	fd.Close()
+	if err != nil {
+		log.Fatal(listFile, ": ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	b := jobs.Batch{
+		Client:      client,
+		Dir:         ".",
+		Concurrency: *concurrency,
+		PerHost:     *perHost,
+		Logger:      newLogger(),
+	}
+	if collector != nil {
+		b.OnEvent = collector.Stream
+	}
+	results := b.Run(ctx, entries)
+
+	var succeeded, skipped int
+	failures := make(map[string][]jobs.Result)
+	var reasons []string
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Err == nil:
+			succeeded++
+		default:
+			reason := errorReason(r.Err)
+			if _, ok := failures[reason]; !ok {
+				reasons = append(reasons, reason)
+			}
+			failures[reason] = append(failures[reason], r)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d downloaded, %d skipped, %d failed\n", succeeded, skipped, len(results)-succeeded-skipped)
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "%s:\n", reason)
+		for _, r := range failures[reason] {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", r.URL, r.Err)
+		}
+	}
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
 }
 
 func main() {
@@ -30,36 +366,130 @@ func main() {
 
 	flag.Parse()
 
-	if len(flag.CommandLine.Args()) < 3 {
+	args := flag.CommandLine.Args()
+	mirror := len(args) > 0 && args[0] == "mirror"
+	recording := len(args) > 0 && args[0] == "record"
+	serving := len(args) > 0 && args[0] == "serve"
+	batching := len(args) > 0 && args[0] == "batch"
+	relaying := len(args) > 0 && args[0] == "relay"
+	if mirror || recording || serving || batching || relaying {
+		args = args[1:]
+	}
+	if batching && len(args) == 1 {
+		batch(newClient(), args[0])
+		return
+	} else if len(args) < 2 {
 		printUsageLine()
+		os.Exit(2)
 	}
 
-	playlist := flag.CommandLine.Arg(0)
-	filename := flag.CommandLine.Arg(1)
+	playlist := args[0]
+	output := args[1]
 
-	fd, err := os.Create(filename)
-	if err != nil {
+	client := newClient()
+
+	if recording {
+		record(client, playlist, output)
+		return
+	} else if serving {
+		serve(client, args[0], args[1])
+		return
+	}
+
+	d, err := dam.Detect(context.TODO(), client, playlist)
+	if errors.Is(err, dam.ErrUnknownProtocol) {
+		d = dam.Progressive{Client: client, Connections: *connections}
+	} else if err != nil {
 		log.Fatal(err)
 	}
+	d = jobs.SetLogger(d, newLogger())
+
+	hlsClient, isHLS := d.(*hls.Client)
+	if mirror && !isHLS {
+		log.Fatal("mirror is only supported for HLS")
+	} else if relaying && !isHLS {
+		log.Fatal("relay is only supported for HLS")
+	} else if mirror && output == "-" {
+		log.Fatal("mirror needs a directory")
+	}
+
+	if isHLS {
+		if collector != nil {
+			hlsClient.OnEvent = collector.Stream(playlist)
+		}
 
-	hlsClient := hls.Client{
-		Client: http.DefaultClient,
+		switch *start {
+		case "default":
+			hlsClient.Start = hls.StartDefault
+		case "live-edge":
+			hlsClient.Start = hls.StartLiveEdge
+		case "dvr":
+			hlsClient.Start = hls.StartDVR
+		default:
+			log.Fatal("unknown start position: ", *start)
+		}
 	}
 
-	variants, err := hlsClient.ListVariants(playlist)
+	formats, err := d.ListFormats(context.TODO(), playlist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	selected, err := dam.SelectFormat(formats, *format)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if !mirror && !relaying && dam.IsTemplate(output) {
+		output = expandOutput(d, playlist, selected, output)
+	}
 
-	var highestBandwidth *m3u8.Variant
-	for _, v := range variants {
-		if highestBandwidth == nil || v.Bandwidth > highestBandwidth.Bandwidth {
-			highestBandwidth = v
+	if p, ok := d.(dam.Progressive); ok && output == "-" {
+		fd := create(output)
+		if err := p.DownloadFormat(context.TODO(), playlist, selected, fd, nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if ok {
+		if *restart {
+			os.Remove(output)
 		}
+		if err := p.DownloadFile(context.TODO(), selected.URI, output, nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if !isHLS {
+		download(d, playlist, selected, output)
+		return
 	}
 
-	err = hlsClient.Download(context.TODO(), highestBandwidth.URI, fd)
-	if err != nil {
+	if *fillGaps {
+		hlsClient.FillGaps = fallbackFormats(formats, selected)
+	}
+
+	// A Media Playlist has neither session data nor renditions to mirror.
+	master, err := hlsClient.ReadMasterPlaylist(context.TODO(), playlist)
+	if err == nil {
+		printSession(*hlsClient, master)
+	} else if mirror {
 		log.Fatal(err)
 	}
+
+	var variant *m3u8.Variant
+	if master != nil {
+		for _, v := range master.Variants {
+			if v.URI == selected.URI {
+				variant = v
+			}
+		}
+	}
+
+	if relaying {
+		relay(hlsClient, selected.URI, variant, output)
+	} else if mirror {
+		err := hlsClient.Mirror(context.TODO(), master, variant, output)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		download(d, playlist, selected, output)
+	}
 }
@@ -0,0 +1,367 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+type Client struct {
+	Client *http.Client
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+
+	// Clock, if not nil, is what the MPD is reloaded and requests retried
+	// by, instead of the real time.
+	Clock dam.Clock
+}
+
+func (c Client) logger() *slog.Logger {
+	return dam.Logger(c.Logger)
+}
+
+func (c Client) clock() dam.Clock {
+	return dam.ClockOrReal(c.Clock)
+}
+
+func init() {
+	dam.Register(dam.Protocol{
+		Name:         "dash",
+		Extensions:   []string{".mpd"},
+		ContentTypes: []string{"application/dash+xml"},
+		Sniff: func(prefix []byte) bool {
+			return bytes.Contains(prefix, []byte("<MPD"))
+		},
+		New: func(client *http.Client) dam.Downloader {
+			return &Client{Client: client}
+		},
+	})
+}
+
+func (c Client) ReadMPD(ctx context.Context, uri string) (*MPD, error) {
+	var mpd *MPD
+	err := dam.RetryClock(ctx, c.clock(), 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		// add a resonable timeout
+		ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+		defer cancel()
+
+		r, err := c.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if r.StatusCode != 200 {
+			return dam.RetryStatus(r)
+		}
+
+		// Relative URLs are resolved against where the MPD was actually
+		// loaded from, after any redirects.
+		mpd, err = parseMPD(r.Body, r.Request.URL.String())
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+		return nil
+	}, nil)
+	return mpd, err
+}
+
+// ListRepresentations lists the Representations of the first Period.
+func (c Client) ListRepresentations(uri string) ([]*Representation, error) {
+	mpd, err := c.ReadMPD(context.TODO(), uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var reps []*Representation
+	for _, as := range mpd.Periods[0].AdaptationSets {
+		reps = append(reps, as.Representations...)
+	}
+	return reps, nil
+}
+
+// ListFormats lists the Representations of the first Period.
+func (c Client) ListFormats(ctx context.Context, uri string) ([]dam.Format, error) {
+	mpd, err := c.ReadMPD(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var formats []dam.Format
+	for _, as := range mpd.Periods[0].AdaptationSets {
+		for _, rep := range as.Representations {
+			f := dam.Format{
+				ID:        rep.ID,
+				URI:       uri,
+				Bandwidth: rep.Bandwidth,
+				Width:     rep.Width,
+				Height:    rep.Height,
+				Codecs:    rep.Codecs,
+			}
+			if f.Codecs == "" {
+				f.Codecs = as.Codecs
+			}
+			formats = append(formats, f)
+		}
+	}
+	return formats, nil
+}
+
+// DownloadFormat downloads the Representation with the ID of format.
+func (c Client) DownloadFormat(ctx context.Context, uri string, format dam.Format, dst io.Writer, progress func(dam.Progress)) error {
+	return c.download(ctx, uri, format.ID, dst, progress)
+}
+
+// Describe tells the extension of the Representation with the ID of format,
+// from its MIME type, and, for a live presentation, when it became
+// available.
+func (c Client) Describe(ctx context.Context, uri string, format dam.Format, fields *dam.Fields) error {
+	mpd, err := c.ReadMPD(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	if !mpd.AvailabilityStartTime.IsZero() {
+		fields.Time = mpd.AvailabilityStartTime.Time
+	}
+	fields.Ext = "mp4"
+	for _, as := range mpd.Periods[0].AdaptationSets {
+		for _, rep := range as.Representations {
+			if rep.ID != format.ID {
+				continue
+			}
+			mimeType := rep.MimeType
+			if mimeType == "" {
+				mimeType = as.MimeType
+			}
+			if i := strings.IndexByte(mimeType, '/'); i >= 0 {
+				fields.Ext = mimeType[i+1:]
+			}
+		}
+	}
+	return nil
+}
+
+// contentType guesses the type of media of a Representation, like video or
+// audio.
+func contentType(rep *Representation) string {
+	if rep.AdaptationSet.ContentType != "" {
+		return rep.AdaptationSet.ContentType
+	}
+	mimeType := rep.MimeType
+	if mimeType == "" {
+		mimeType = rep.AdaptationSet.MimeType
+	}
+	for i, c := range mimeType {
+		if c == '/' {
+			return mimeType[:i]
+		}
+	}
+	return mimeType
+}
+
+// findRepresentation looks for the Representation with the given ID in
+// period.  Periods inserted in a presentation, like ads, usually have other
+// IDs; in that case the Representation of the same type of media with the
+// closest bandwidth is used.
+func findRepresentation(period *Period, like *Representation) *Representation {
+	var closest *Representation
+	var closestDistance uint64
+	for _, as := range period.AdaptationSets {
+		for _, rep := range as.Representations {
+			if rep.ID == like.ID {
+				return rep
+			}
+			if like.AdaptationSet == nil || contentType(rep) != contentType(like) {
+				continue
+			}
+
+			distance := rep.Bandwidth - like.Bandwidth
+			if rep.Bandwidth < like.Bandwidth {
+				distance = like.Bandwidth - rep.Bandwidth
+			}
+			if closest == nil || distance < closestDistance {
+				closest, closestDistance = rep, distance
+			}
+		}
+	}
+	return closest
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (c Client) fetch(ctx context.Context, seg Segment, dst io.Writer) error {
+	return dam.RetryClock(ctx, c.clock(), 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", seg.URL, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+		if seg.Range != "" {
+			req.Header.Set("Range", "bytes="+seg.Range)
+		}
+
+		r, err := c.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if seg.Range != "" && r.StatusCode == 200 {
+			return dam.StopRetrying(dam.ErrByterangeUnsupported)
+		} else if r.StatusCode != 200 && r.StatusCode != 206 {
+			return dam.RetryStatus(r)
+		}
+
+		// Once some of the segment has been written, it cannot be retried.
+		if _, err := io.Copy(dst, r.Body); err != nil {
+			return dam.StopRetrying(err)
+		}
+		return nil
+	}, nil)
+}
+
+// Download writes the Initialization Segment and the Media Segments of the
+// Representation with the given ID to dst.  Dynamic presentations are
+// followed, reloading the MPD, until they become static or end.
+func (c Client) Download(ctx context.Context, uri, representationID string, dst io.Writer) error {
+	return c.download(ctx, uri, representationID, dst, nil)
+}
+
+func (c Client) download(ctx context.Context, uri, representationID string, dst io.Writer, progress func(dam.Progress)) error {
+	like := &Representation{ID: representationID}
+	counter := &countingWriter{Writer: dst}
+	var segmentsWritten int
+
+	var started bool
+	var lastInit, lastPeriod string
+	var lastPeriodIndex int
+	var nextTime uint64
+
+	for {
+		c.logger().Debug("downloading MPD", "uri", uri)
+
+		lastLoadedMPD := c.clock().Now()
+		mpd, err := c.ReadMPD(ctx, uri)
+		if err != nil {
+			return err
+		}
+
+		// Periods before the one being downloaded are skipped.  It is found
+		// again by its ID, which dynamic presentations are required to
+		// give, or else by its position.
+		current := -1
+		if started {
+			current = lastPeriodIndex
+			for i, period := range mpd.Periods {
+				if lastPeriod != "" && period.ID == lastPeriod {
+					current = i
+				}
+			}
+		}
+
+		var downloaded bool
+		var segmentDuration time.Duration
+		for i := max(current, 0); i < len(mpd.Periods); i++ {
+			period := mpd.Periods[i]
+			rep := findRepresentation(period, like)
+			if rep == nil {
+				return fmt.Errorf("Representation %s not found in Period %s", like.ID, period.ID)
+			}
+
+			init, segments, err := mpd.Segments(rep, c.clock().Now())
+			if err != nil {
+				return err
+			}
+
+			if i != current {
+				nextTime = 0
+				if !started && mpd.Type == "dynamic" && len(segments) > 0 {
+					// begin at the live edge, like players do
+					nextTime = segments[len(segments)-1].Time
+				}
+				like = rep
+				current = i
+				lastPeriod, lastPeriodIndex = period.ID, i
+				started = true
+			}
+
+			if init != nil && init.URL != lastInit {
+				c.logger().Debug("downloading initialization segment", "uri", init.URL)
+				if err := c.fetch(ctx, *init, counter); err != nil {
+					return err
+				}
+				lastInit = init.URL
+			}
+
+			for _, seg := range segments {
+				segmentDuration = seg.end() - seg.start()
+				if seg.Time < nextTime {
+					continue
+				}
+
+				c.logger().Debug("downloading segment", "uri", seg.URL, "seq", seg.Number)
+				if err := c.fetch(ctx, seg, counter); err != nil {
+					return err
+				}
+				downloaded = true
+
+				segmentsWritten++
+				if progress != nil {
+					progress(dam.Progress{Segments: segmentsWritten, Bytes: counter.n})
+				}
+
+				// A single segment holding the whole Representation has no
+				// duration.
+				nextTime = seg.Time + seg.Duration
+				if seg.Duration == 0 {
+					nextTime++
+				}
+			}
+		}
+
+		if mpd.Type == "static" {
+			return nil
+		}
+
+		end := mpd.AvailabilityStartTime.Add(time.Duration(mpd.MediaPresentationDuration))
+		if mpd.MediaPresentationDuration != 0 && c.clock().Now().After(end) && !downloaded {
+			return nil
+		}
+
+		// Without MPD@minimumUpdatePeriod the MPD does not change, but new
+		// segments still become available as time passes.
+		wait := time.Duration(mpd.MinimumUpdatePeriod)
+		if wait <= 0 {
+			wait = segmentDuration
+		}
+		if wait <= 0 {
+			wait = 2 * time.Second
+		}
+		if err := dam.Sleep(ctx, c.clock(), lastLoadedMPD.Add(wait).Sub(c.clock().Now())); err != nil {
+			return err
+		}
+	}
+}
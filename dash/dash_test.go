@@ -0,0 +1,128 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+	"github.com/otommod/go-dam/hls/hlstest"
+)
+
+func TestDownload(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Static MPDs often leave the IDs of their Periods out.
+	manifests := map[string]string{"/manifest.mpd": `id="main" `, "/no-ids.mpd": ""}
+	for path, id := range manifests {
+		manifest := fmt.Sprintf(`
+			<MPD type="static" mediaPresentationDuration="PT8S">
+			  <Period %sduration="PT6S">
+			    <AdaptationSet mimeType="video/mp4">
+			      <SegmentTemplate duration="2" initialization="$RepresentationID$-init.mp4" media="$RepresentationID$-$Number$.m4s"/>
+			      <Representation id="low" bandwidth="100000"/>
+			      <Representation id="high" bandwidth="900000"/>
+			    </AdaptationSet>
+			  </Period>
+			  <Period %sduration="PT2S">
+			    <AdaptationSet mimeType="video/mp4">
+			      <SegmentTemplate duration="2" media="ad-$RepresentationID$.m4s"/>
+			      <Representation id="ad-low" bandwidth="120000"/>
+			      <Representation id="ad-high" bandwidth="1000000"/>
+			    </AdaptationSet>
+			  </Period>
+			</MPD>
+		`, id, strings.Replace(id, "main", "ad", 1))
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			io.WriteString(w, manifest)
+		})
+	}
+
+	for _, name := range []string{"high-init.mp4", "high-1.m4s", "high-2.m4s", "high-3.m4s", "ad-ad-high.m4s"} {
+		name := name
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			io.WriteString(w, name+"\n")
+		})
+	}
+
+	c := Client{
+		Client: srv.Client(),
+	}
+
+	reps, err := c.ListRepresentations(srv.URL + "/manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 2 || reps[1].ID != "high" {
+		t.Fatalf("expected Representations low and high, found %v", reps)
+	}
+
+	expected := "high-init.mp4\nhigh-1.m4s\nhigh-2.m4s\nhigh-3.m4s\nad-ad-high.m4s\n"
+	for path := range manifests {
+		var buf bytes.Buffer
+		if err := c.Download(context.Background(), srv.URL+path, "high", &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: expected\n%s\nfound\n%s", path, expected, buf.String())
+		}
+	}
+
+	// a missing MPD is not tried again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var httpErr dam.HTTPError
+	if _, err := c.ReadMPD(ctx, srv.URL+"/missing.mpd"); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Errorf("expected a 404, found %v", err)
+	}
+}
+
+func TestDownloadLive(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/live.mpd", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			<MPD type="dynamic" availabilityStartTime="2020-01-01T00:00:00Z" mediaPresentationDuration="PT8S" timeShiftBufferDepth="PT6S">
+			  <Period id="1" start="PT0S">
+			    <AdaptationSet mimeType="video/mp4">
+			      <SegmentTemplate duration="2" media="$Number$.m4s"/>
+			      <Representation id="v1" bandwidth="1000"/>
+			    </AdaptationSet>
+			  </Period>
+			</MPD>
+		`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, r.URL.Path[1:]+"\n")
+	})
+
+	// three seconds in, only the first segment is available; the rest are
+	// waited for on the clock
+	c := Client{
+		Client: srv.Client(),
+		Clock:  hlstest.NewAutoClock(time.Date(2020, 1, 1, 0, 0, 3, 0, time.UTC)),
+	}
+
+	var buf bytes.Buffer
+	if err := c.Download(context.Background(), srv.URL+"/live.mpd", "v1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1.m4s\n2.m4s\n3.m4s\n4.m4s\n"; buf.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,572 @@
+package dash
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is an xs:duration, like PT1H30M2.5S.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "P") {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	s = s[1:]
+
+	units := map[byte]time.Duration{
+		'Y': 365 * 24 * time.Hour,
+		'D': 24 * time.Hour,
+		'H': time.Hour,
+		'S': time.Second,
+	}
+
+	var total float64
+	var inTime bool
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+
+		i := strings.IndexAny(s, "YMDHS")
+		if i <= 0 {
+			return fmt.Errorf("invalid duration %q", text)
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", text)
+		}
+
+		unit := units[s[i]]
+		if s[i] == 'M' && inTime {
+			unit = time.Minute
+		} else if s[i] == 'M' {
+			unit = 30 * 24 * time.Hour
+		}
+		total += n * float64(unit)
+		s = s[i+1:]
+	}
+
+	*d = Duration(total)
+	return nil
+}
+
+// DateTime is an xs:dateTime; the time zone is optional and defaults to UTC.
+type DateTime struct {
+	time.Time
+}
+
+func (t *DateTime) UnmarshalText(text []byte) (err error) {
+	t.Time, err = time.Parse(time.RFC3339Nano, string(text))
+	if err != nil {
+		t.Time, err = time.Parse("2006-01-02T15:04:05.999999999", string(text))
+	}
+	return
+}
+
+type MPD struct {
+	XMLName                    xml.Name  `xml:"MPD"`
+	Type                       string    `xml:"type,attr"`
+	MediaPresentationDuration  Duration  `xml:"mediaPresentationDuration,attr"`
+	MinimumUpdatePeriod        Duration  `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              Duration  `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       Duration  `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay Duration  `xml:"suggestedPresentationDelay,attr"`
+	AvailabilityStartTime      DateTime  `xml:"availabilityStartTime,attr"`
+	PublishTime                DateTime  `xml:"publishTime,attr"`
+	BaseURL                    []string  `xml:"BaseURL"`
+	Periods                    []*Period `xml:"Period"`
+
+	// URL is where the MPD was loaded from, the base of every relative URL.
+	URL *url.URL `xml:"-"`
+}
+
+type Period struct {
+	ID              string           `xml:"id,attr"`
+	Start           Duration         `xml:"start,attr"`
+	Duration        Duration         `xml:"duration,attr"`
+	BaseURL         []string         `xml:"BaseURL"`
+	SegmentBase     *SegmentBase     `xml:"SegmentBase"`
+	SegmentList     *SegmentList     `xml:"SegmentList"`
+	SegmentTemplate *SegmentTemplate `xml:"SegmentTemplate"`
+	AdaptationSets  []*AdaptationSet `xml:"AdaptationSet"`
+}
+
+type AdaptationSet struct {
+	ID              string            `xml:"id,attr"`
+	ContentType     string            `xml:"contentType,attr"`
+	MimeType        string            `xml:"mimeType,attr"`
+	Codecs          string            `xml:"codecs,attr"`
+	Lang            string            `xml:"lang,attr"`
+	BaseURL         []string          `xml:"BaseURL"`
+	SegmentBase     *SegmentBase      `xml:"SegmentBase"`
+	SegmentList     *SegmentList      `xml:"SegmentList"`
+	SegmentTemplate *SegmentTemplate  `xml:"SegmentTemplate"`
+	Representations []*Representation `xml:"Representation"`
+}
+
+type Representation struct {
+	ID                string           `xml:"id,attr"`
+	Bandwidth         uint64           `xml:"bandwidth,attr"`
+	Width             int              `xml:"width,attr"`
+	Height            int              `xml:"height,attr"`
+	FrameRate         string           `xml:"frameRate,attr"`
+	AudioSamplingRate string           `xml:"audioSamplingRate,attr"`
+	MimeType          string           `xml:"mimeType,attr"`
+	Codecs            string           `xml:"codecs,attr"`
+	BaseURL           []string         `xml:"BaseURL"`
+	SegmentBase       *SegmentBase     `xml:"SegmentBase"`
+	SegmentList       *SegmentList     `xml:"SegmentList"`
+	SegmentTemplate   *SegmentTemplate `xml:"SegmentTemplate"`
+
+	// AdaptationSet and Period are the elements the Representation is in.
+	AdaptationSet *AdaptationSet `xml:"-"`
+	Period        *Period        `xml:"-"`
+}
+
+// URLType is the type of the Initialization and RepresentationIndex elements.
+type URLType struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+type SegmentBase struct {
+	Timescale      uint64   `xml:"timescale,attr"`
+	IndexRange     string   `xml:"indexRange,attr"`
+	Initialization *URLType `xml:"Initialization"`
+}
+
+type SegmentURL struct {
+	Media      string `xml:"media,attr"`
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+type SegmentList struct {
+	Timescale      uint64       `xml:"timescale,attr"`
+	Duration       uint64       `xml:"duration,attr"`
+	StartNumber    *uint64      `xml:"startNumber,attr"`
+	Initialization *URLType     `xml:"Initialization"`
+	SegmentURLs    []SegmentURL `xml:"SegmentURL"`
+}
+
+type SegmentTimelineEntry struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int64   `xml:"r,attr"`
+}
+
+type SegmentTimeline struct {
+	S []SegmentTimelineEntry `xml:"S"`
+}
+
+type SegmentTemplate struct {
+	Media                  string           `xml:"media,attr"`
+	Initialization         string           `xml:"initialization,attr"`
+	Timescale              uint64           `xml:"timescale,attr"`
+	Duration               uint64           `xml:"duration,attr"`
+	StartNumber            *uint64          `xml:"startNumber,attr"`
+	PresentationTimeOffset uint64           `xml:"presentationTimeOffset,attr"`
+	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline"`
+}
+
+// inherit fills in the attributes missing from t with those of parent; the
+// SegmentTemplate of an AdaptationSet serves as the default for its
+// Representations, and that of a Period for its AdaptationSets.
+func (t *SegmentTemplate) inherit(parent *SegmentTemplate) *SegmentTemplate {
+	if t == nil {
+		return parent
+	} else if parent == nil {
+		return t
+	}
+
+	merged := *t
+	if merged.Media == "" {
+		merged.Media = parent.Media
+	}
+	if merged.Initialization == "" {
+		merged.Initialization = parent.Initialization
+	}
+	if merged.Timescale == 0 {
+		merged.Timescale = parent.Timescale
+	}
+	if merged.Duration == 0 {
+		merged.Duration = parent.Duration
+	}
+	if merged.StartNumber == nil {
+		merged.StartNumber = parent.StartNumber
+	}
+	if merged.PresentationTimeOffset == 0 {
+		merged.PresentationTimeOffset = parent.PresentationTimeOffset
+	}
+	if merged.SegmentTimeline == nil {
+		merged.SegmentTimeline = parent.SegmentTimeline
+	}
+	return &merged
+}
+
+func parseMPD(r io.Reader, mpdURI string) (*MPD, error) {
+	mpdURL, err := url.Parse(mpdURI)
+	if err != nil {
+		return nil, err
+	}
+
+	mpd := new(MPD)
+	if err := xml.NewDecoder(r).Decode(mpd); err != nil {
+		return nil, err
+	}
+	mpd.URL = mpdURL
+
+	if mpd.Type == "" {
+		mpd.Type = "static"
+	} else if mpd.Type != "static" && mpd.Type != "dynamic" {
+		return nil, fmt.Errorf("invalid MPD@type %q", mpd.Type)
+	}
+
+	if len(mpd.Periods) == 0 {
+		return nil, errors.New("MPD has no Period")
+	}
+
+	for i, p := range mpd.Periods {
+		// A Period without @start begins when the previous one ends.
+		if prev := i - 1; prev >= 0 && p.Start == 0 && mpd.Periods[prev].Duration != 0 {
+			p.Start = mpd.Periods[prev].Start + mpd.Periods[prev].Duration
+		}
+
+		for _, as := range p.AdaptationSets {
+			for _, rep := range as.Representations {
+				rep.AdaptationSet = as
+				rep.Period = p
+			}
+		}
+	}
+
+	return mpd, nil
+}
+
+// periodDuration returns the duration of the i-th Period, or zero if it is
+// not known yet, as in the last Period of a live presentation.
+func (mpd *MPD) periodDuration(i int) time.Duration {
+	p := mpd.Periods[i]
+	switch {
+	case p.Duration != 0:
+		return time.Duration(p.Duration)
+	case i+1 < len(mpd.Periods):
+		return time.Duration(mpd.Periods[i+1].Start - p.Start)
+	case mpd.MediaPresentationDuration != 0:
+		return time.Duration(mpd.MediaPresentationDuration - p.Start)
+	}
+	return 0
+}
+
+// resolveBaseURL resolves the BaseURL elements down to rep, keeping the
+// first of each level.
+func (mpd *MPD) resolveBaseURL(rep *Representation) (*url.URL, error) {
+	base := mpd.URL
+	for _, baseURLs := range [][]string{mpd.BaseURL, rep.Period.BaseURL, rep.AdaptationSet.BaseURL, rep.BaseURL} {
+		if len(baseURLs) == 0 {
+			continue
+		}
+		u, err := base.Parse(strings.TrimSpace(baseURLs[0]))
+		if err != nil {
+			return nil, err
+		}
+		base = u
+	}
+	return base, nil
+}
+
+// expandTemplate substitutes the identifiers of a SegmentTemplate, like
+// $Number$ or $Time%05d$.
+func expandTemplate(template string, rep *Representation, number, t uint64) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		b.WriteString(template[:i])
+		template = template[i+1:]
+
+		j := strings.IndexByte(template, '$')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated identifier in template %q", template)
+		}
+		ident := template[:j]
+		template = template[j+1:]
+
+		format := "%d"
+		if k := strings.IndexByte(ident, '%'); k >= 0 {
+			ident, format = ident[:k], ident[k:]
+		}
+
+		switch ident {
+		case "":
+			b.WriteByte('$')
+		case "RepresentationID":
+			b.WriteString(rep.ID)
+		case "Number":
+			fmt.Fprintf(&b, format, number)
+		case "Bandwidth":
+			fmt.Fprintf(&b, format, rep.Bandwidth)
+		case "Time":
+			fmt.Fprintf(&b, format, t)
+		default:
+			return "", fmt.Errorf("unknown identifier $%s$ in template", ident)
+		}
+	}
+}
+
+// Segment is a Media Segment or an Initialization Segment of a
+// Representation.  Range is an HTTP byte range, like 0-1023, or empty for the
+// whole resource.
+type Segment struct {
+	URL    string
+	Range  string
+	Number uint64
+
+	// Time and Duration are in units of Timescale.
+	Time, Duration, Timescale uint64
+}
+
+// start returns the time the segment starts at, relative to the beginning of
+// its Period.
+func (s Segment) start() time.Duration {
+	if s.Timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(s.Time) / float64(s.Timescale) * float64(time.Second))
+}
+
+func (s Segment) end() time.Duration {
+	if s.Timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(s.Time+s.Duration) / float64(s.Timescale) * float64(time.Second))
+}
+
+// Segments lists the Initialization Segment, if there is one, and the Media
+// Segments of rep.  For dynamic presentations, only those available at now
+// are listed.
+func (mpd *MPD) Segments(rep *Representation, now time.Time) (init *Segment, segments []Segment, err error) {
+	base, err := mpd.resolveBaseURL(rep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var periodIndex int
+	for i, p := range mpd.Periods {
+		if p == rep.Period {
+			periodIndex = i
+		}
+	}
+	periodDuration := mpd.periodDuration(periodIndex)
+
+	// the media time up to which segments are available, relative to the
+	// start of the period
+	availableUntil := periodDuration
+	var availableFrom time.Duration
+	if mpd.Type == "dynamic" {
+		availableUntil = now.Sub(mpd.AvailabilityStartTime.Time) - time.Duration(rep.Period.Start)
+		if periodDuration != 0 && availableUntil > periodDuration {
+			availableUntil = periodDuration
+		}
+
+		// Without a time shift buffer, every segment since the start of the
+		// Period is available; only those close to the live edge are listed.
+		window := time.Duration(mpd.TimeShiftBufferDepth)
+		if window == 0 {
+			window = time.Duration(mpd.SuggestedPresentationDelay + mpd.MinBufferTime)
+		}
+		availableFrom = availableUntil - window
+	}
+
+	resolve := func(ref string) (string, error) {
+		if ref == "" {
+			return base.String(), nil
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+
+	template := rep.SegmentTemplate.inherit(rep.AdaptationSet.SegmentTemplate.inherit(rep.Period.SegmentTemplate))
+	list := rep.SegmentList
+	if list == nil {
+		list = rep.AdaptationSet.SegmentList
+	}
+	if list == nil {
+		list = rep.Period.SegmentList
+	}
+	segmentBase := rep.SegmentBase
+	if segmentBase == nil {
+		segmentBase = rep.AdaptationSet.SegmentBase
+	}
+	if segmentBase == nil {
+		segmentBase = rep.Period.SegmentBase
+	}
+
+	switch {
+	case template != nil:
+		timescale := template.Timescale
+		if timescale == 0 {
+			timescale = 1
+		}
+		number := uint64(1)
+		if template.StartNumber != nil {
+			number = *template.StartNumber
+		}
+
+		if template.Initialization != "" {
+			ref, err := expandTemplate(template.Initialization, rep, 0, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			u, err := resolve(ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			init = &Segment{URL: u}
+		}
+
+		add := func(t, d uint64) error {
+			ref, err := expandTemplate(template.Media, rep, number, t)
+			if err != nil {
+				return err
+			}
+			u, err := resolve(ref)
+			if err != nil {
+				return err
+			}
+
+			s := Segment{URL: u, Number: number, Time: t - template.PresentationTimeOffset, Duration: d, Timescale: timescale}
+			if s.start() >= availableFrom {
+				segments = append(segments, s)
+			}
+			number++
+			return nil
+		}
+
+		if template.SegmentTimeline != nil {
+			var t uint64
+			entries := template.SegmentTimeline.S
+			for i, s := range entries {
+				if s.T != nil {
+					t = *s.T
+				}
+
+				if s.D == 0 {
+					return nil, nil, errors.New("SegmentTimeline has an S with no @d")
+				}
+
+				// A negative repeat count means the segment repeats until
+				// the start of the next S element or the end of the Period.
+				repeat := s.R
+				if repeat < 0 {
+					until := int64(template.PresentationTimeOffset) + int64(availableUntil.Seconds()*float64(timescale))
+					if i+1 < len(entries) && entries[i+1].T != nil {
+						until = int64(*entries[i+1].T)
+					}
+					repeat = (until-int64(t))/int64(s.D) - 1
+				}
+
+				for r := int64(0); r <= repeat; r++ {
+					seg := Segment{Time: t - template.PresentationTimeOffset, Duration: s.D, Timescale: timescale}
+					if periodDuration != 0 && seg.start() >= periodDuration {
+						return init, segments, nil
+					}
+					if mpd.Type == "dynamic" && seg.end() > availableUntil {
+						return init, segments, nil
+					}
+					if err := add(t, s.D); err != nil {
+						return nil, nil, err
+					}
+					t += s.D
+				}
+			}
+		} else if template.Duration != 0 {
+			var first uint64
+			if availableFrom > 0 {
+				first = uint64(availableFrom.Seconds() * float64(timescale) / float64(template.Duration))
+			}
+			number += first
+
+			for i := first; ; i++ {
+				s := Segment{Time: i * template.Duration, Duration: template.Duration, Timescale: timescale}
+				if mpd.Type == "dynamic" && s.end() > availableUntil {
+					break
+				} else if mpd.Type == "static" && s.start() >= periodDuration {
+					break
+				}
+				if err := add(s.Time+template.PresentationTimeOffset, s.Duration); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else {
+			return nil, nil, errors.New("SegmentTemplate has neither @duration nor SegmentTimeline")
+		}
+
+	case list != nil:
+		timescale := list.Timescale
+		if timescale == 0 {
+			timescale = 1
+		}
+		number := uint64(1)
+		if list.StartNumber != nil {
+			number = *list.StartNumber
+		}
+
+		if list.Initialization != nil {
+			u, err := resolve(list.Initialization.SourceURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			init = &Segment{URL: u, Range: list.Initialization.Range}
+		}
+
+		for i, segURL := range list.SegmentURLs {
+			u, err := resolve(segURL.Media)
+			if err != nil {
+				return nil, nil, err
+			}
+			segments = append(segments, Segment{
+				URL:       u,
+				Range:     segURL.MediaRange,
+				Number:    number + uint64(i),
+				Time:      uint64(i) * list.Duration,
+				Duration:  list.Duration,
+				Timescale: timescale,
+			})
+		}
+
+	default:
+		// A single segment, with SegmentBase or with just a BaseURL, holds
+		// the whole Representation, usually including its initialization.
+		if segmentBase != nil && segmentBase.Initialization != nil && segmentBase.Initialization.SourceURL != "" {
+			u, err := resolve(segmentBase.Initialization.SourceURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			init = &Segment{URL: u, Range: segmentBase.Initialization.Range}
+		}
+
+		u, err := resolve("")
+		if err != nil {
+			return nil, nil, err
+		}
+		segments = append(segments, Segment{URL: u})
+	}
+
+	return init, segments, nil
+}
@@ -0,0 +1,227 @@
+package dash
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected time.Duration
+	}{
+		{"PT0S", 0},
+		{"PT2.5S", 2500 * time.Millisecond},
+		{"PT1H30M2S", time.Hour + 30*time.Minute + 2*time.Second},
+		{"P1DT1M", 24*time.Hour + time.Minute},
+	}
+
+	for _, test := range tests {
+		var d Duration
+		if err := d.UnmarshalText([]byte(test.text)); err != nil {
+			t.Errorf("%s: %v", test.text, err)
+		} else if time.Duration(d) != test.expected {
+			t.Errorf("%s: expected %v, found %v", test.text, test.expected, time.Duration(d))
+		}
+	}
+
+	for _, text := range []string{"", "T1S", "PTS", "PT1X"} {
+		var d Duration
+		if err := d.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	rep := &Representation{ID: "video-1", Bandwidth: 800000}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"$RepresentationID$/$Number$.m4s", "video-1/7.m4s"},
+		{"$Bandwidth$/$Time$.m4s", "800000/90000.m4s"},
+		{"seg-$Number%05d$.m4s", "seg-00007.m4s"},
+		{"cost$$$Number$", "cost$7"},
+	}
+
+	for _, test := range tests {
+		actual, err := expandTemplate(test.template, rep, 7, 90000)
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+		} else if actual != test.expected {
+			t.Errorf("%s: expected %s, found %s", test.template, test.expected, actual)
+		}
+	}
+
+	for _, template := range []string{"$Number", "$Unknown$"} {
+		if _, err := expandTemplate(template, rep, 7, 90000); err == nil {
+			t.Errorf("%s: expected an error", template)
+		}
+	}
+}
+
+func segmentURLs(segments []Segment) []string {
+	var urls []string
+	for _, s := range segments {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		mpd      string
+		init     string
+		segments []string
+	}{
+		{
+			name: "template with duration",
+			mpd: `
+				<MPD type="static" mediaPresentationDuration="PT10S">
+				  <BaseURL>media/</BaseURL>
+				  <Period>
+				    <AdaptationSet mimeType="video/mp4">
+				      <SegmentTemplate timescale="1000" duration="4000" startNumber="0"
+				        initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s"/>
+				      <Representation id="v1" bandwidth="1000"/>
+				    </AdaptationSet>
+				  </Period>
+				</MPD>`,
+			init: "http://example.com/dash/media/v1/init.mp4",
+			segments: []string{
+				"http://example.com/dash/media/v1/0.m4s",
+				"http://example.com/dash/media/v1/1.m4s",
+				"http://example.com/dash/media/v1/2.m4s",
+			},
+		},
+		{
+			name: "template with timeline",
+			mpd: `
+				<MPD mediaPresentationDuration="PT8S">
+				  <Period>
+				    <AdaptationSet mimeType="audio/mp4">
+				      <Representation id="a1" bandwidth="64000">
+				        <SegmentTemplate timescale="10" media="$Time$.m4s">
+				          <SegmentTimeline>
+				            <S t="0" d="20" r="1"/>
+				            <S d="10" r="-1"/>
+				          </SegmentTimeline>
+				        </SegmentTemplate>
+				      </Representation>
+				    </AdaptationSet>
+				  </Period>
+				</MPD>`,
+			segments: []string{
+				"http://example.com/dash/0.m4s",
+				"http://example.com/dash/20.m4s",
+				"http://example.com/dash/40.m4s",
+				"http://example.com/dash/50.m4s",
+				"http://example.com/dash/60.m4s",
+				"http://example.com/dash/70.m4s",
+			},
+		},
+		{
+			name: "segment list",
+			mpd: `
+				<MPD mediaPresentationDuration="PT4S">
+				  <Period>
+				    <AdaptationSet>
+				      <Representation id="v1" bandwidth="1000">
+				        <BaseURL>http://cdn.example.com/v1/</BaseURL>
+				        <SegmentList duration="2">
+				          <Initialization sourceURL="init.mp4"/>
+				          <SegmentURL media="a.m4s"/>
+				          <SegmentURL media="b.m4s"/>
+				        </SegmentList>
+				      </Representation>
+				    </AdaptationSet>
+				  </Period>
+				</MPD>`,
+			init: "http://cdn.example.com/v1/init.mp4",
+			segments: []string{
+				"http://cdn.example.com/v1/a.m4s",
+				"http://cdn.example.com/v1/b.m4s",
+			},
+		},
+		{
+			name: "base url",
+			mpd: `
+				<MPD mediaPresentationDuration="PT4S">
+				  <Period>
+				    <AdaptationSet>
+				      <Representation id="v1" bandwidth="1000">
+				        <BaseURL>/videos/v1.mp4</BaseURL>
+				        <SegmentBase indexRange="800-1000">
+				          <Initialization range="0-799"/>
+				        </SegmentBase>
+				      </Representation>
+				    </AdaptationSet>
+				  </Period>
+				</MPD>`,
+			segments: []string{
+				"http://example.com/videos/v1.mp4",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		mpd, err := parseMPD(strings.NewReader(test.mpd), "http://example.com/dash/manifest.mpd")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		rep := mpd.Periods[0].AdaptationSets[0].Representations[0]
+		init, segments, err := mpd.Segments(rep, time.Now())
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if init == nil && test.init != "" {
+			t.Errorf("%s: expected initialization %s, found none", test.name, test.init)
+		} else if init != nil && init.URL != test.init {
+			t.Errorf("%s: expected initialization %q, found %q", test.name, test.init, init.URL)
+		}
+		if actual := segmentURLs(segments); !reflect.DeepEqual(actual, test.segments) {
+			t.Errorf("%s: expected segments\n%v\nfound\n%v", test.name, test.segments, actual)
+		}
+	}
+}
+
+func TestDynamicSegments(t *testing.T) {
+	mpd, err := parseMPD(strings.NewReader(`
+		<MPD type="dynamic" availabilityStartTime="2020-01-01T00:00:00Z" timeShiftBufferDepth="PT6S">
+		  <Period id="1" start="PT0S">
+		    <AdaptationSet mimeType="video/mp4">
+		      <SegmentTemplate duration="2" media="$Number$.m4s"/>
+		      <Representation id="v1" bandwidth="1000"/>
+		    </AdaptationSet>
+		  </Period>
+		</MPD>`), "http://example.com/live.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 61 seconds in, the segments that have ended and that started in the
+	// last 6 seconds are available
+	now := time.Date(2020, 1, 1, 0, 1, 1, 0, time.UTC)
+	rep := mpd.Periods[0].AdaptationSets[0].Representations[0]
+	_, segments, err := mpd.Segments(rep, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"http://example.com/29.m4s",
+		"http://example.com/30.m4s",
+	}
+	if actual := segmentURLs(segments); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected segments\n%v\nfound\n%v", expected, actual)
+	}
+}
@@ -0,0 +1,190 @@
+package dam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is one of the qualities a stream is available in, like a variant of
+// an HLS Master Playlist or a Representation of a DASH MPD.
+type Format struct {
+	ID string
+
+	// URI is what the Downloader needs to find the format again; what it
+	// points to depends on the protocol.
+	URI string
+
+	Bandwidth     uint64
+	Width, Height int
+	Codecs        string
+}
+
+// Progress is how much of a format has been downloaded so far.
+type Progress struct {
+	Segments int
+	Bytes    int64
+}
+
+// Downloader is implemented by the clients of every streaming protocol.
+type Downloader interface {
+	// ListFormats lists the formats of the stream at uri.
+	ListFormats(ctx context.Context, uri string) ([]Format, error)
+
+	// DownloadFormat writes format, one of the formats of the stream at
+	// uri, to dst.  If progress is not nil, it is called after every
+	// segment.
+	DownloadFormat(ctx context.Context, uri string, format Format, dst io.Writer, progress func(Progress)) error
+}
+
+// SelectFormat picks one of formats: the one with the highest bandwidth for
+// "best", the one with the lowest for "worst", or else the one with that ID.
+func SelectFormat(formats []Format, spec string) (Format, error) {
+	if len(formats) == 0 {
+		return Format{}, errors.New("no formats found")
+	}
+
+	selected := formats[0]
+	for _, f := range formats[1:] {
+		switch spec {
+		case "best":
+			if f.Bandwidth > selected.Bandwidth {
+				selected = f
+			}
+		case "worst":
+			if f.Bandwidth < selected.Bandwidth {
+				selected = f
+			}
+		}
+	}
+	if spec == "best" || spec == "worst" {
+		return selected, nil
+	}
+
+	for _, f := range formats {
+		if f.ID == spec {
+			return f, nil
+		}
+	}
+	return Format{}, fmt.Errorf("format %q not found", spec)
+}
+
+// Protocol describes how to recognize a streaming protocol and how to create
+// a Downloader for it.
+type Protocol struct {
+	Name string
+
+	// Extensions and ContentTypes are matched against the path of the URL
+	// and the Content-Type of the response, like ".m3u8" and
+	// "application/vnd.apple.mpegurl".
+	Extensions   []string
+	ContentTypes []string
+
+	// Sniff reports whether the first bytes of the response look like the
+	// protocol.
+	Sniff func(prefix []byte) bool
+
+	New func(client *http.Client) Downloader
+}
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   []Protocol
+)
+
+// Register makes a protocol available to Detect.  It is meant to be called
+// from the init function of the package implementing the protocol.
+func Register(p Protocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	protocols = append(protocols, p)
+}
+
+// ErrUnknownProtocol is returned by Detect for streams of no registered
+// protocol, which may well be plain files.
+var ErrUnknownProtocol = errors.New("unknown streaming protocol")
+
+// sniffLen is how many bytes are given to Protocol.Sniff.
+const sniffLen = 512
+
+// Detect returns a Downloader for the stream at uri, telling its protocol
+// from the extension of the URL or, failing that, from the Content-Type and
+// the first bytes of the response.
+func Detect(ctx context.Context, client *http.Client, uri string) (Downloader, error) {
+	protocolsMu.RLock()
+	protocols := protocols
+	protocolsMu.RUnlock()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, p := range protocols {
+		for _, e := range p.Extensions {
+			if ext == e {
+				return p.New(client), nil
+			}
+		}
+	}
+
+	var contentType string
+	var prefix []byte
+	err = Retry(ctx, 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return StopRetrying(err)
+		}
+
+		r, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if r.StatusCode != 200 {
+			return RetryStatus(r)
+		}
+
+		contentType, _, _ = mime.ParseMediaType(r.Header.Get("Content-Type"))
+		prefix = make([]byte, sniffLen)
+		n, err := io.ReadFull(r.Body, prefix)
+		prefix = prefix[:n]
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range protocols {
+		for _, t := range p.ContentTypes {
+			if contentType == t {
+				return p.New(client), nil
+			}
+		}
+	}
+
+	// Sniffing is the last resort, for servers that send a generic
+	// Content-Type, like text/plain or application/octet-stream.
+	prefix = bytes.TrimLeft(prefix, "\ufeff \t\r\n")
+	for _, p := range protocols {
+		if p.Sniff != nil && p.Sniff(prefix) {
+			return p.New(client), nil
+		}
+	}
+
+	return nil, ErrUnknownProtocol
+}
@@ -0,0 +1,95 @@
+package dam
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeDownloader struct{}
+
+func (d fakeDownloader) ListFormats(ctx context.Context, uri string) ([]Format, error) {
+	return nil, nil
+}
+
+func (d fakeDownloader) DownloadFormat(ctx context.Context, uri string, format Format, dst io.Writer, progress func(Progress)) error {
+	return nil
+}
+
+func TestSelectFormat(t *testing.T) {
+	formats := []Format{
+		{ID: "a", Bandwidth: 200},
+		{ID: "b", Bandwidth: 800},
+		{ID: "c", Bandwidth: 100},
+	}
+
+	for spec, expected := range map[string]string{"best": "b", "worst": "c", "a": "a"} {
+		f, err := SelectFormat(formats, spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+		} else if f.ID != expected {
+			t.Errorf("%s: expected %s, found %s", spec, expected, f.ID)
+		}
+	}
+
+	if _, err := SelectFormat(formats, "d"); err == nil {
+		t.Error("expected an error for an unknown ID")
+	}
+	if _, err := SelectFormat(nil, "best"); err == nil {
+		t.Error("expected an error for no formats")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	Register(Protocol{
+		Name:         "fake",
+		Extensions:   []string{".fake"},
+		ContentTypes: []string{"application/x-fake"},
+		Sniff: func(prefix []byte) bool {
+			return string(prefix) == "#FAKE"
+		},
+		New: func(client *http.Client) Downloader {
+			return fakeDownloader{}
+		},
+	})
+
+	missingRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/content-type":
+			w.Header().Set("Content-Type", "application/x-fake; charset=utf-8")
+		case "/sniff":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "\ufeff\n#FAKE")
+		case "/unknown":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello")
+		default:
+			missingRequests++
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/stream.FAKE?token=1", "/content-type", "/sniff"} {
+		d, err := Detect(context.Background(), srv.Client(), srv.URL+path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if d != (fakeDownloader{}) {
+			t.Errorf("%s: expected the fake protocol, found %v", path, d)
+		}
+	}
+
+	if _, err := Detect(context.Background(), srv.Client(), srv.URL+"/unknown"); err != ErrUnknownProtocol {
+		t.Errorf("expected ErrUnknownProtocol, found %v", err)
+	}
+
+	// a 404 will not go away by trying again
+	var httpErr HTTPError
+	if _, err := Detect(context.Background(), srv.Client(), srv.URL+"/missing"); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 || missingRequests != 1 {
+		t.Errorf("expected a 404 after a single request, found %d requests and %v", missingRequests, err)
+	}
+}
@@ -1,13 +1,86 @@
 package dam
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"syscall"
 )
 
+// bodySnippetSize is how much of the body of a failed response HTTPError
+// keeps.
+const bodySnippetSize = 512
+
+// HTTPError is returned when a server answers with an unexpected status.
 type HTTPError struct {
 	*http.Response
+
+	// URL is the URL that was requested, after any redirects.
+	URL string
+
+	// Attempt is the number of the attempt that failed, when the request
+	// was retried, or zero.
+	Attempt int
+
+	// Body is the beginning of the body of the response, which often
+	// explains the error.
+	Body []byte
+}
+
+// NewHTTPError makes an HTTPError of r, reading the beginning of its body.
+func NewHTTPError(r *http.Response) HTTPError {
+	e := HTTPError{Response: r}
+	if r.Request != nil {
+		e.URL = r.Request.URL.String()
+	}
+	if r.Body != nil {
+		e.Body, _ = ioutil.ReadAll(io.LimitReader(r.Body, bodySnippetSize))
+	}
+	return e
 }
 
 func (e HTTPError) Error() string {
-	return e.Status
+	if e.URL == "" {
+		return e.Status
+	}
+	return e.URL + ": " + e.Status
+}
+
+// ChangedError is returned when a file changed on the server while it was
+// being downloaded, or since the download that is being resumed began.
+type ChangedError struct {
+	URL string
+}
+
+func (e ChangedError) Error() string {
+	return e.URL + " changed on the server"
+}
+
+// ErrNotSupported is matched, with errors.Is, by every NotSupportedError.
+var ErrNotSupported = errors.New("not supported")
+
+// NotSupportedError is returned when a stream uses a feature of its protocol
+// that cannot be downloaded, like encryption.
+type NotSupportedError struct {
+	Feature string
+}
+
+func (e NotSupportedError) Error() string {
+	return e.Feature + " not supported"
+}
+
+func (e NotSupportedError) Is(target error) bool {
+	return target == ErrNotSupported
+}
+
+// ErrByterangeUnsupported is returned when a server ignores the Range header
+// of a request for part of a file that must be downloaded in parts.
+var ErrByterangeUnsupported = errors.New("byte ranges not supported by the server")
+
+// IsClosedPipe tells whether err is from writing to a pipe whose reader has
+// gone away, like a program that the output is piped into and that exited.
+// Downloads stop there, cleanly rather than with an error.
+func IsClosedPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrClosedPipe)
 }
@@ -0,0 +1,42 @@
+package dam
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		io.WriteString(w, "not here")
+	}))
+	defer srv.Close()
+
+	err := Retry(context.Background(), 700*time.Millisecond, func() error {
+		r, err := srv.Client().Get(srv.URL + "/file")
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+		return NewHTTPError(r)
+	})
+
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected an HTTPError, found %v", err)
+	}
+	if httpErr.URL != srv.URL+"/file" {
+		t.Errorf("expected URL %s, found %s", srv.URL+"/file", httpErr.URL)
+	}
+	if httpErr.Attempt != 2 {
+		t.Errorf("expected attempt 2, found %d", httpErr.Attempt)
+	}
+	if string(httpErr.Body) != "not here" {
+		t.Errorf("expected body %q, found %q", "not here", httpErr.Body)
+	}
+}
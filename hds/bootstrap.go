@@ -0,0 +1,234 @@
+package hds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Bootstrap is the bootstrap info box, abst, of Adobe's F4V specification.
+// It tells which fragments make up the presentation.
+type Bootstrap struct {
+	Version          uint32
+	Live             bool
+	Update           bool
+	TimeScale        uint32
+	CurrentMediaTime uint64
+	MovieIdentifier  string
+	Segments         []SegmentRun
+	Fragments        []FragmentRun
+
+	// FragmentTimeScale is the timescale of the fragment run table.
+	FragmentTimeScale uint32
+}
+
+// SegmentRun is an entry of the segment run table, asrt.
+type SegmentRun struct {
+	FirstSegment        uint32
+	FragmentsPerSegment uint32
+}
+
+// FragmentRun is an entry of the fragment run table, afrt.  An entry with no
+// duration signals a discontinuity, or the end of the presentation.
+type FragmentRun struct {
+	FirstFragment          uint32
+	FirstFragmentTimestamp uint64
+	FragmentDuration       uint32
+	DiscontinuityIndicator uint8
+}
+
+// boxReader reads the fields of a box.
+type boxReader struct {
+	data []byte
+	err  error
+}
+
+func (r *boxReader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.data) < n {
+		r.err = errors.New("truncated bootstrap info")
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *boxReader) u8() uint8   { return r.read(1)[0] }
+func (r *boxReader) u32() uint32 { return binary.BigEndian.Uint32(r.read(4)) }
+func (r *boxReader) u64() uint64 { return binary.BigEndian.Uint64(r.read(8)) }
+
+func (r *boxReader) string() string {
+	i := bytes.IndexByte(r.data, 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string in bootstrap info")
+		return ""
+	}
+	return string(r.read(i + 1)[:i])
+}
+
+func (r *boxReader) strings() []string {
+	s := make([]string, r.u8())
+	for i := range s {
+		s[i] = r.string()
+	}
+	return s
+}
+
+// box reads the header of a box and returns a reader of its payload.
+func (r *boxReader) box(typ string) *boxReader {
+	size := int(r.u32())
+	if t := string(r.read(4)); r.err == nil && t != typ {
+		r.err = fmt.Errorf("expected %s box, found %q", typ, t)
+	}
+	if size == 1 {
+		size = int(r.u64()) - 8
+	}
+	if r.err != nil || size < 8 {
+		return &boxReader{err: r.err}
+	}
+	return &boxReader{data: r.read(size - 8), err: r.err}
+}
+
+func parseBootstrap(data []byte) (*Bootstrap, error) {
+	r := &boxReader{data: data}
+	abst := r.box("abst")
+	abst.u32() // version and flags
+
+	b := new(Bootstrap)
+	b.Version = abst.u32()
+	flags := abst.u8()
+	b.Live = flags&0x20 != 0
+	b.Update = flags&0x10 != 0
+	b.TimeScale = abst.u32()
+	b.CurrentMediaTime = abst.u64()
+	abst.u64() // SMPTE time code offset
+	b.MovieIdentifier = abst.string()
+	abst.strings() // servers
+	abst.strings() // qualities
+	abst.string()  // DRM data
+	abst.string()  // metadata
+
+	for i := abst.u8(); i > 0 && abst.err == nil; i-- {
+		asrt := abst.box("asrt")
+		asrt.u32() // version and flags
+		asrt.strings()
+		for j := asrt.u32(); j > 0 && asrt.err == nil; j-- {
+			b.Segments = append(b.Segments, SegmentRun{
+				FirstSegment:        asrt.u32(),
+				FragmentsPerSegment: asrt.u32(),
+			})
+		}
+		if asrt.err != nil {
+			return nil, asrt.err
+		}
+	}
+
+	for i := abst.u8(); i > 0 && abst.err == nil; i-- {
+		afrt := abst.box("afrt")
+		afrt.u32() // version and flags
+		b.FragmentTimeScale = afrt.u32()
+		afrt.strings()
+		for j := afrt.u32(); j > 0 && afrt.err == nil; j-- {
+			f := FragmentRun{
+				FirstFragment:          afrt.u32(),
+				FirstFragmentTimestamp: afrt.u64(),
+				FragmentDuration:       afrt.u32(),
+			}
+			if f.FragmentDuration == 0 {
+				f.DiscontinuityIndicator = afrt.u8()
+			}
+			b.Fragments = append(b.Fragments, f)
+		}
+		if afrt.err != nil {
+			return nil, afrt.err
+		}
+	}
+
+	if abst.err != nil {
+		return nil, abst.err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(b.Segments) == 0 || len(b.Fragments) == 0 {
+		return nil, errors.New("bootstrap info has no segment or fragment runs")
+	}
+	return b, nil
+}
+
+// SegFrag identifies a fragment; it is requested as SegN-FragM.
+type SegFrag struct {
+	Segment, Fragment uint32
+}
+
+// lastFragment returns the number of the last fragment that is complete by
+// CurrentMediaTime, if it can be told from the fragment run table.
+func (b *Bootstrap) lastFragment() (uint32, bool) {
+	for i := len(b.Fragments) - 1; i >= 0; i-- {
+		f := b.Fragments[i]
+		if f.FragmentDuration == 0 {
+			if f.DiscontinuityIndicator == 0 {
+				// the end of the presentation
+				return 0, false
+			}
+			continue
+		}
+
+		if b.CurrentMediaTime == 0 || b.TimeScale == 0 {
+			return 0, false
+		}
+		current := b.CurrentMediaTime * uint64(b.FragmentTimeScale) / uint64(b.TimeScale)
+		if current < f.FirstFragmentTimestamp+uint64(f.FragmentDuration) {
+			return f.FirstFragment - 1, true
+		}
+		complete := (current - f.FirstFragmentTimestamp) / uint64(f.FragmentDuration)
+		return f.FirstFragment + uint32(complete) - 1, true
+	}
+	return 0, false
+}
+
+// maxFragments stops the list of fragments of a bootstrap that claims an
+// endless number of them and gives no way to tell how many there are.
+const maxFragments = 1 << 16
+
+// fragments lists the fragments of the presentation, in order.
+func (b *Bootstrap) fragments() []SegFrag {
+	last, lastKnown := b.lastFragment()
+
+	var frags []SegFrag
+	fragment := b.Fragments[0].FirstFragment
+	for i, run := range b.Segments {
+		lastSegment := run.FirstSegment
+		if i+1 < len(b.Segments) {
+			lastSegment = b.Segments[i+1].FirstSegment - 1
+		}
+
+		for segment := run.FirstSegment; segment <= lastSegment; segment++ {
+			// Live streams claim an endless number of fragments per
+			// segment; the fragment run table tells how many there are.
+			for j := uint32(0); j < run.FragmentsPerSegment; j++ {
+				if (lastKnown && fragment > last) || len(frags) >= maxFragments {
+					return frags
+				}
+				frags = append(frags, SegFrag{segment, fragment})
+				fragment++
+			}
+		}
+	}
+	return frags
+}
+
+// fragmentDuration is the duration of the last run of fragments, in seconds,
+// or zero if unknown.
+func (b *Bootstrap) fragmentDuration() float64 {
+	for i := len(b.Fragments) - 1; i >= 0; i-- {
+		if d := b.Fragments[i].FragmentDuration; d != 0 && b.FragmentTimeScale != 0 {
+			return float64(d) / float64(b.FragmentTimeScale)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package hds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// abst builds a bootstrap info box with a single segment and fragment run
+// table.
+func abst(live bool, currentMediaTime uint64, segments []SegmentRun, fragments []FragmentRun) []byte {
+	box := func(typ string, payload []byte) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, binary.BigEndian, uint32(8+len(payload)))
+		b.WriteString(typ)
+		b.Write(payload)
+		return b.Bytes()
+	}
+
+	var asrt bytes.Buffer
+	binary.Write(&asrt, binary.BigEndian, uint32(0)) // version and flags
+	asrt.WriteByte(0)                                // qualities
+	binary.Write(&asrt, binary.BigEndian, uint32(len(segments)))
+	for _, s := range segments {
+		binary.Write(&asrt, binary.BigEndian, s)
+	}
+
+	var afrt bytes.Buffer
+	binary.Write(&afrt, binary.BigEndian, uint32(0))    // version and flags
+	binary.Write(&afrt, binary.BigEndian, uint32(1000)) // timescale
+	afrt.WriteByte(0)                                   // qualities
+	binary.Write(&afrt, binary.BigEndian, uint32(len(fragments)))
+	for _, f := range fragments {
+		binary.Write(&afrt, binary.BigEndian, f.FirstFragment)
+		binary.Write(&afrt, binary.BigEndian, f.FirstFragmentTimestamp)
+		binary.Write(&afrt, binary.BigEndian, f.FragmentDuration)
+		if f.FragmentDuration == 0 {
+			afrt.WriteByte(f.DiscontinuityIndicator)
+		}
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(0)) // version and flags
+	binary.Write(&b, binary.BigEndian, uint32(1)) // bootstrap info version
+	if live {
+		b.WriteByte(0x20)
+	} else {
+		b.WriteByte(0)
+	}
+	binary.Write(&b, binary.BigEndian, uint32(1000))
+	binary.Write(&b, binary.BigEndian, currentMediaTime)
+	binary.Write(&b, binary.BigEndian, uint64(0))
+	b.WriteString("movie\x00")
+	b.Write([]byte{0, 0, 0, 0}) // servers, qualities, DRM data and metadata
+	b.WriteByte(1)
+	b.Write(box("asrt", asrt.Bytes()))
+	b.WriteByte(1)
+	b.Write(box("afrt", afrt.Bytes()))
+	return box("abst", b.Bytes())
+}
+
+func TestBootstrap(t *testing.T) {
+	tests := []struct {
+		name      string
+		bootstrap []byte
+		expected  []SegFrag
+	}{
+		{
+			name: "vod",
+			bootstrap: abst(false, 12000,
+				[]SegmentRun{{1, 3}},
+				[]FragmentRun{{1, 0, 4000, 0}}),
+			expected: []SegFrag{{1, 1}, {1, 2}, {1, 3}},
+		},
+		{
+			name: "segments",
+			bootstrap: abst(false, 0,
+				[]SegmentRun{{1, 2}, {3, 1}},
+				[]FragmentRun{{1, 0, 4000, 0}}),
+			expected: []SegFrag{{1, 1}, {1, 2}, {2, 3}, {2, 4}, {3, 5}},
+		},
+		{
+			name: "live",
+			bootstrap: abst(true, 410000,
+				[]SegmentRun{{1, 0xffffffff}},
+				[]FragmentRun{{100, 400000, 4000, 0}}),
+			expected: []SegFrag{{1, 100}, {1, 101}},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := parseBootstrap(test.bootstrap)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if actual := b.fragments(); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected fragments %v, found %v", test.name, test.expected, actual)
+		}
+	}
+
+	if _, err := parseBootstrap(abst(false, 0, nil, nil)[:40]); err == nil {
+		t.Error("expected an error for a truncated bootstrap")
+	}
+}
@@ -0,0 +1,112 @@
+package hds
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/otommod/go-dam"
+)
+
+type Manifest struct {
+	XMLName       xml.Name         `xml:"manifest"`
+	ID            string           `xml:"id"`
+	StreamType    string           `xml:"streamType"`
+	Duration      float64          `xml:"duration"`
+	BaseURL       string           `xml:"baseURL"`
+	BootstrapInfo []*BootstrapInfo `xml:"bootstrapInfo"`
+	Media         []*Media         `xml:"media"`
+	DRM           []struct{}       `xml:"drmAdditionalHeader"`
+
+	// URL is where the manifest was loaded from.
+	URL *url.URL `xml:"-"`
+}
+
+// BootstrapInfo is given either inline, base64 encoded, or by URL.
+type BootstrapInfo struct {
+	ID      string `xml:"id,attr"`
+	Profile string `xml:"profile,attr"`
+	URL     string `xml:"url,attr"`
+	Data    string `xml:",chardata"`
+}
+
+type Media struct {
+	StreamID        string `xml:"streamId,attr"`
+	URL             string `xml:"url,attr"`
+	Href            string `xml:"href,attr"`
+	Bitrate         uint64 `xml:"bitrate,attr"`
+	Width           int    `xml:"width,attr"`
+	Height          int    `xml:"height,attr"`
+	BootstrapInfoID string `xml:"bootstrapInfoId,attr"`
+	Metadata        string `xml:"metadata"`
+}
+
+func parseManifest(r io.Reader, manifestURI string) (*Manifest, error) {
+	manifestURL, err := url.Parse(manifestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Manifest)
+	if err := xml.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+	m.URL = manifestURL
+
+	if len(m.DRM) > 0 {
+		return nil, dam.NotSupportedError{Feature: "protected manifests"}
+	}
+	for _, media := range m.Media {
+		if media.Href != "" {
+			return nil, dam.NotSupportedError{Feature: "multi-level manifests"}
+		}
+	}
+	return m, nil
+}
+
+// base is what the URLs of the manifest are relative to.
+func (m *Manifest) base() (*url.URL, error) {
+	if m.BaseURL == "" {
+		return m.URL, nil
+	}
+
+	base := strings.TrimSpace(m.BaseURL)
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return m.URL.Parse(base)
+}
+
+// bootstrap finds the bootstrapInfo of media.
+func (m *Manifest) bootstrap(media *Media) (*BootstrapInfo, error) {
+	for _, b := range m.BootstrapInfo {
+		if b.ID == media.BootstrapInfoID || media.BootstrapInfoID == "" {
+			return b, nil
+		}
+	}
+	return nil, errors.New("bootstrapInfo of media not found")
+}
+
+// mediaURL is the URL the Seg-Frag suffix is appended to.
+func (m *Manifest) mediaURL(media *Media) (string, error) {
+	base, err := m.base()
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(strings.TrimSpace(media.URL))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// metadata returns the onMetaData script data of media, encoded in AMF0.
+func (media *Media) metadata() ([]byte, error) {
+	if strings.TrimSpace(media.Metadata) == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(media.Metadata))
+}
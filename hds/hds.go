@@ -0,0 +1,316 @@
+package hds
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func init() {
+	dam.Register(dam.Protocol{
+		Name:         "hds",
+		Extensions:   []string{".f4m"},
+		ContentTypes: []string{"application/f4m+xml"},
+		Sniff: func(prefix []byte) bool {
+			return bytes.Contains(prefix, []byte("ns.adobe.com/f4m"))
+		},
+		New: func(client *http.Client) dam.Downloader {
+			return &Client{Client: client}
+		},
+	})
+}
+
+type Client struct {
+	Client *http.Client
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+
+	// Clock, if not nil, is what the bootstrap is reloaded and requests retried
+	// by, instead of the real time.
+	Clock dam.Clock
+}
+
+func (c Client) logger() *slog.Logger {
+	return dam.Logger(c.Logger)
+}
+
+func (c Client) clock() dam.Clock {
+	return dam.ClockOrReal(c.Clock)
+}
+
+func (c Client) get(ctx context.Context, uri string) ([]byte, string, error) {
+	var data []byte
+	var loadedFrom string
+	err := dam.RetryClock(ctx, c.clock(), 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		// add a resonable timeout
+		ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+		defer cancel()
+
+		r, err := c.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if r.StatusCode != 200 {
+			return dam.RetryStatus(r)
+		}
+
+		loadedFrom = r.Request.URL.String()
+		data, err = ioutil.ReadAll(r.Body)
+		return err
+	}, nil)
+	return data, loadedFrom, err
+}
+
+func (c Client) ReadManifest(ctx context.Context, uri string) (*Manifest, error) {
+	data, loadedFrom, err := c.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(bytes.NewReader(data), loadedFrom)
+}
+
+// ReadBootstrap loads the bootstrap info of media, from the manifest itself
+// or from its URL.
+func (c Client) ReadBootstrap(ctx context.Context, m *Manifest, media *Media) (*Bootstrap, error) {
+	info, err := m.bootstrap(media)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.URL == "" {
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(info.Data), ""))
+		if err != nil {
+			return nil, err
+		}
+		return parseBootstrap(data)
+	}
+
+	base, err := m.base()
+	if err != nil {
+		return nil, err
+	}
+	u, err := base.Parse(info.URL)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := c.get(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	return parseBootstrap(data)
+}
+
+// ListFormats lists the media of the manifest at uri.
+func (c Client) ListFormats(ctx context.Context, uri string) ([]dam.Format, error) {
+	m, err := c.ReadManifest(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var formats []dam.Format
+	for i, media := range m.Media {
+		formats = append(formats, dam.Format{
+			ID:        strconv.Itoa(i),
+			URI:       uri,
+			Bandwidth: media.Bitrate * 1000,
+			Width:     media.Width,
+			Height:    media.Height,
+		})
+	}
+	return formats, nil
+}
+
+func (c Client) DownloadFormat(ctx context.Context, uri string, format dam.Format, dst io.Writer, progress func(dam.Progress)) error {
+	i, err := strconv.Atoi(format.ID)
+	if err != nil {
+		return fmt.Errorf("invalid format %q", format.ID)
+	}
+	return c.download(ctx, uri, i, dst, progress)
+}
+
+// Describe tells the extension of the file that a format is downloaded to;
+// it is always FLV.
+func (c Client) Describe(ctx context.Context, uri string, format dam.Format, fields *dam.Fields) error {
+	fields.Ext = "flv"
+	return nil
+}
+
+// flvHeader starts an FLV file with audio and video.
+var flvHeader = []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+
+// scriptTag wraps AMF0 data in an FLV script data tag.
+func scriptTag(data []byte) []byte {
+	tag := make([]byte, 11, 11+len(data)+4)
+	tag[0] = 18
+	tag[1], tag[2], tag[3] = byte(len(data)>>16), byte(len(data)>>8), byte(len(data))
+	tag = append(tag, data...)
+
+	previousTagSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(previousTagSize, uint32(len(tag)))
+	return append(tag, previousTagSize...)
+}
+
+// flvTags returns the FLV tags that a fragment, an F4F file, carries in its
+// mdat box.
+func flvTags(fragment []byte) ([]byte, error) {
+	for len(fragment) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(fragment))
+		typ := string(fragment[4:8])
+		header := uint64(8)
+		if size == 1 {
+			if len(fragment) < 16 {
+				break
+			}
+			size, header = binary.BigEndian.Uint64(fragment[8:]), 16
+		} else if size == 0 {
+			size = uint64(len(fragment))
+		}
+		if size < header || size > uint64(len(fragment)) {
+			return nil, fmt.Errorf("invalid size of box %q", typ)
+		}
+
+		if typ == "mdat" {
+			return fragment[header:size], nil
+		}
+		fragment = fragment[size:]
+	}
+	return nil, errors.New("fragment has no mdat box")
+}
+
+// Download writes the media with the given index in the manifest at uri to
+// dst as an FLV file.  The bootstrap info of live streams is reloaded until
+// they end.
+func (c Client) Download(ctx context.Context, uri string, index int, dst io.Writer) error {
+	return c.download(ctx, uri, index, dst, nil)
+}
+
+func (c Client) download(ctx context.Context, uri string, index int, dst io.Writer, progress func(dam.Progress)) error {
+	m, err := c.ReadManifest(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(m.Media) {
+		return fmt.Errorf("media %d not found", index)
+	}
+	media := m.Media[index]
+
+	mediaURL, err := m.mediaURL(media)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(flvHeader); err != nil {
+		return err
+	}
+	metadata, err := media.metadata()
+	if err != nil {
+		return err
+	} else if metadata != nil {
+		if _, err := dst.Write(scriptTag(metadata)); err != nil {
+			return err
+		}
+	}
+
+	var started bool
+	var nextFragment uint32
+	var done dam.Progress
+
+	for {
+		c.logger().Debug("downloading bootstrap info", "uri", mediaURL)
+
+		lastLoadedBootstrap := c.clock().Now()
+		bootstrap, err := c.ReadBootstrap(ctx, m, media)
+		if err != nil {
+			return err
+		}
+
+		frags := bootstrap.fragments()
+		if !started {
+			if bootstrap.Live && len(frags) > 0 {
+				// begin at the live edge, like players do
+				nextFragment = frags[len(frags)-1].Fragment
+			}
+			started = true
+		}
+
+		var downloaded bool
+		for _, f := range frags {
+			if f.Fragment < nextFragment {
+				continue
+			}
+
+			fragmentURL := fmt.Sprintf("%sSeg%d-Frag%d", mediaURL, f.Segment, f.Fragment)
+			c.logger().Debug("downloading fragment", "uri", fragmentURL, "seq", f.Fragment)
+			fragment, _, err := c.get(ctx, fragmentURL)
+			if err != nil {
+				return err
+			}
+			tags, err := flvTags(fragment)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fragmentURL, err)
+			}
+
+			n, err := dst.Write(tags)
+			if err != nil {
+				return err
+			}
+			nextFragment = f.Fragment + 1
+			downloaded = true
+
+			done.Segments++
+			done.Bytes += int64(n)
+			if progress != nil {
+				progress(done)
+			}
+		}
+
+		if !bootstrap.Live {
+			return nil
+		}
+
+		// Like HLS playlists, the bootstrap info is reloaded after a
+		// fragment duration if it had new fragments, after half of one if
+		// it did not.
+		wait := time.Duration(bootstrap.fragmentDuration() * float64(time.Second))
+		if wait <= 0 {
+			wait = 2 * time.Second
+		}
+		if !downloaded {
+			wait /= 2
+		}
+		if err := dam.Sleep(ctx, c.clock(), lastLoadedBootstrap.Add(wait).Sub(c.clock().Now())); err != nil {
+			return err
+		}
+
+		// Inline bootstrap info can only be refreshed with the manifest.
+		if info, err := m.bootstrap(media); err == nil && info.URL == "" {
+			if m, err = c.ReadManifest(ctx, uri); err != nil {
+				return err
+			}
+			if index >= len(m.Media) {
+				return fmt.Errorf("media %d not found", index)
+			}
+			media = m.Media[index]
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package hds
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func TestDownload(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	bootstrap := abst(false, 8000, []SegmentRun{{1, 2}}, []FragmentRun{{1, 0, 4000, 0}})
+	metadata := []byte("onMetaData")
+
+	mux.HandleFunc("/vod/manifest.f4m", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+			<manifest xmlns="http://ns.adobe.com/f4m/1.0">
+			  <id>vod</id>
+			  <streamType>recorded</streamType>
+			  <bootstrapInfo profile="named" id="bootstrap0">%s</bootstrapInfo>
+			  <media streamId="low" url="low" bitrate="400" bootstrapInfoId="bootstrap0"/>
+			  <media streamId="high" url="high" bitrate="1200" bootstrapInfoId="bootstrap0">
+			    <metadata>%s</metadata>
+			  </media>
+			</manifest>`,
+			base64.StdEncoding.EncodeToString(bootstrap),
+			base64.StdEncoding.EncodeToString(metadata))
+	})
+
+	for _, name := range []string{"highSeg1-Frag1", "highSeg1-Frag2"} {
+		name := name
+		mux.HandleFunc("/vod/"+name, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			// an afra box, which is skipped, and the mdat with the tags
+			w.Write([]byte{0, 0, 0, 8, 'a', 'f', 'r', 'a'})
+			w.Write([]byte{0, 0, 0, byte(8 + len(name)), 'm', 'd', 'a', 't'})
+			io.WriteString(w, name)
+		})
+	}
+
+	c := Client{
+		Client: srv.Client(),
+	}
+
+	formats, err := c.ListFormats(context.Background(), srv.URL+"/vod/manifest.f4m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(formats) != 2 || formats[1].Bandwidth != 1200000 {
+		t.Fatalf("expected 2 formats, the second of 1200000 bps, found %v", formats)
+	}
+
+	var buf bytes.Buffer
+	if err := c.DownloadFormat(context.Background(), srv.URL+"/vod/manifest.f4m", formats[1], &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	expected.Write(flvHeader)
+	expected.Write(scriptTag(metadata))
+	expected.WriteString("highSeg1-Frag1highSeg1-Frag2")
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Errorf("expected\n%q\nfound\n%q", expected.Bytes(), buf.Bytes())
+	}
+
+	// a missing manifest is not tried again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var httpErr dam.HTTPError
+	if _, err := c.ListFormats(ctx, srv.URL+"/vod/missing.f4m"); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Errorf("expected a 404, found %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package hls
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The conformance corpus holds the examples of RFC 8216 § 8 and of the
+// appendix of Apple's revision of the specification, along with a few
+// playlists in the shape of those found in the wild.  The expected result of
+// parsing each one, encoded back, is checked in next to it as a golden file.
+const conformanceURI = "http://example.com/playlist.m3u8"
+
+func conformanceCorpus(t testing.TB) []string {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("empty conformance corpus")
+	}
+	return files
+}
+
+func TestConformance(t *testing.T) {
+	for _, file := range conformanceCorpus(t) {
+		name := strings.TrimSuffix(filepath.Base(file), ".m3u8")
+		t.Run(name, func(t *testing.T) {
+			input, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			playlist, _, err := parseM3U8(bytes.NewReader(input), conformanceURI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPlaylist(t, playlist)
+			encoded := encode(t, playlist, nil)
+
+			golden := strings.TrimSuffix(file, ".m3u8") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, encoded, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(encoded, expected) {
+				t.Errorf("parsed playlist does not match %s:\n%s", golden, encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+type attributeList []string
+
+func (a *attributeList) add(key, value string) {
+	if value != "" {
+		*a = append(*a, key+"="+value)
+	}
+}
+
+func (a *attributeList) quote(key, value string) {
+	if value != "" {
+		*a = append(*a, key+`="`+value+`"`)
+	}
+}
+
+func (a attributeList) String() string {
+	return strings.Join(a, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatUint(n uint32) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "YES"
+	}
+	return ""
+}
+
+func rewriteWith(rewrite func(string) string) func(string) string {
+	return func(uri string) string {
+		if rewrite == nil || uri == "" {
+			return uri
+		}
+		return rewrite(uri)
+	}
+}
+
+func encodeCommonTags(w io.Writer, tags CommonPlaylistTags) {
+	if tags.IndependentSegments {
+		fmt.Fprintln(w, "#EXT-X-INDEPENDENT-SEGMENTS")
+	}
+	if tags.HasStart {
+		var attrs attributeList
+		attrs.add("TIME-OFFSET", formatFloat(tags.StartOffset.Seconds()))
+		attrs.add("PRECISE", formatBool(tags.StartPrecise))
+		fmt.Fprintf(w, "#EXT-X-START:%s\n", attrs)
+	}
+}
+
+func keyAttributes(key *m3u8.Key, uri func(string) string) attributeList {
+	var attrs attributeList
+	attrs.add("METHOD", key.Method)
+	attrs.quote("URI", uri(key.URI))
+	attrs.add("IV", key.IV)
+	attrs.quote("KEYFORMAT", key.Keyformat)
+	attrs.quote("KEYFORMATVERSIONS", key.Keyformatversions)
+	return attrs
+}
+
+func variantAttributes(v *m3u8.Variant) attributeList {
+	var attrs attributeList
+	attrs.add("PROGRAM-ID", formatUint(v.ProgramId))
+	attrs.add("BANDWIDTH", strconv.FormatUint(uint64(v.Bandwidth), 10))
+	attrs.add("AVERAGE-BANDWIDTH", formatUint(v.AverageBandwidth))
+	attrs.quote("CODECS", v.Codecs)
+	attrs.add("RESOLUTION", v.Resolution)
+	if v.FrameRate != 0 {
+		attrs.add("FRAME-RATE", strconv.FormatFloat(v.FrameRate, 'f', 3, 64))
+	}
+	attrs.quote("AUDIO", v.Audio)
+	attrs.quote("VIDEO", v.Video)
+	attrs.quote("SUBTITLES", v.Subtitles)
+	if v.Captions == "NONE" {
+		attrs.add("CLOSED-CAPTIONS", v.Captions)
+	} else {
+		attrs.quote("CLOSED-CAPTIONS", v.Captions)
+	}
+	attrs.quote("NAME", v.Name)
+	return attrs
+}
+
+// Encode returns the Master Playlist in the M3U8 format, including the tags
+// that m3u8 does not know about.
+func (p *MasterPlaylist) Encode() *bytes.Buffer {
+	var buf bytes.Buffer
+	p.EncodeTo(&buf, nil)
+	return &buf
+}
+
+func (p *MasterPlaylist) String() string {
+	return p.Encode().String()
+}
+
+// EncodeTo writes the Master Playlist to w.  If rewrite is not nil, every URI
+// in the playlist is replaced by the result of calling rewrite on it.
+func (p *MasterPlaylist) EncodeTo(w io.Writer, rewrite func(uri string) string) error {
+	uri := rewriteWith(rewrite)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "#EXTM3U")
+	if v := p.Version(); v > 0 {
+		fmt.Fprintf(bw, "#EXT-X-VERSION:%d\n", v)
+	}
+	encodeCommonTags(bw, p.CommonPlaylistTags)
+
+	for _, data := range p.SessionData {
+		var attrs attributeList
+		attrs.quote("DATA-ID", data.DataID)
+		attrs.quote("VALUE", data.Value)
+		attrs.quote("URI", uri(data.URI))
+		attrs.quote("LANGUAGE", data.Language)
+		fmt.Fprintf(bw, "#EXT-X-SESSION-DATA:%s\n", attrs)
+	}
+
+	for _, key := range p.SessionKeys {
+		fmt.Fprintf(bw, "#EXT-X-SESSION-KEY:%s\n", keyAttributes(key, uri))
+	}
+
+	// Playlists that were not parsed have their renditions only in their
+	// variants, where the same rendition appears in many of them.
+	renditions := p.Renditions
+	if renditions == nil {
+		seen := make(map[*m3u8.Alternative]bool)
+		for _, v := range p.Variants {
+			for _, alt := range v.Alternatives {
+				if !seen[alt] {
+					seen[alt] = true
+					renditions = append(renditions, alt)
+				}
+			}
+		}
+	}
+
+	for _, alt := range renditions {
+		var attrs attributeList
+		attrs.add("TYPE", alt.Type)
+		attrs.quote("GROUP-ID", alt.GroupId)
+		attrs.quote("NAME", alt.Name)
+		attrs.quote("LANGUAGE", alt.Language)
+		attrs.add("DEFAULT", formatBool(alt.Default))
+		attrs.add("AUTOSELECT", alt.Autoselect)
+		attrs.add("FORCED", alt.Forced)
+		attrs.quote("CHARACTERISTICS", alt.Characteristics)
+		attrs.quote("URI", uri(alt.URI))
+		fmt.Fprintf(bw, "#EXT-X-MEDIA:%s\n", attrs)
+	}
+
+	for _, v := range p.Variants {
+		attrs := variantAttributes(v)
+		if v.Iframe {
+			attrs.quote("URI", uri(v.URI))
+			fmt.Fprintf(bw, "#EXT-X-I-FRAME-STREAM-INF:%s\n", attrs)
+		} else {
+			fmt.Fprintf(bw, "#EXT-X-STREAM-INF:%s\n", attrs)
+			fmt.Fprintln(bw, uri(v.URI))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Encode returns the Media Playlist in the M3U8 format, including the tags
+// that m3u8 does not know about.
+func (p *MediaPlaylist) Encode() *bytes.Buffer {
+	var buf bytes.Buffer
+	p.EncodeTo(&buf, nil)
+	return &buf
+}
+
+func (p *MediaPlaylist) String() string {
+	return p.Encode().String()
+}
+
+// EncodeTo writes the Media Playlist to w.  If rewrite is not nil, every URI
+// in the playlist is replaced by the result of calling rewrite on it.
+func (p *MediaPlaylist) EncodeTo(w io.Writer, rewrite func(uri string) string) error {
+	uri := rewriteWith(rewrite)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "#EXTM3U")
+	if v := p.Version(); v > 0 {
+		fmt.Fprintf(bw, "#EXT-X-VERSION:%d\n", v)
+	}
+	fmt.Fprintf(bw, "#EXT-X-TARGETDURATION:%d\n", int64(math.Ceil(p.TargetDuration.Seconds())))
+	if p.SeqNo != 0 {
+		fmt.Fprintf(bw, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.SeqNo)
+	}
+	if p.DiscontinuitySeq != 0 {
+		fmt.Fprintf(bw, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySeq)
+	}
+	switch p.MediaType {
+	case m3u8.EVENT:
+		fmt.Fprintln(bw, "#EXT-X-PLAYLIST-TYPE:EVENT")
+	case m3u8.VOD:
+		fmt.Fprintln(bw, "#EXT-X-PLAYLIST-TYPE:VOD")
+	}
+	if p.Iframe {
+		fmt.Fprintln(bw, "#EXT-X-I-FRAMES-ONLY")
+	}
+	encodeCommonTags(bw, p.CommonPlaylistTags)
+
+	var key *m3u8.Key
+	var xmap *m3u8.Map
+	var bitrate int64
+	var prev *MediaSegment
+	for _, seg := range p.Segments {
+		switch {
+		case seg.Key != nil && (key == nil || *seg.Key != *key):
+			fmt.Fprintf(bw, "#EXT-X-KEY:%s\n", keyAttributes(seg.Key, uri))
+		case seg.Key == nil && key != nil:
+			fmt.Fprintln(bw, "#EXT-X-KEY:METHOD=NONE")
+		}
+		key = seg.Key
+
+		if seg.Map != nil && (xmap == nil || *seg.Map != *xmap) {
+			var attrs attributeList
+			attrs.quote("URI", uri(seg.Map.URI))
+			if seg.Map.Limit > 0 {
+				attrs.quote("BYTERANGE", fmt.Sprintf("%d@%d", seg.Map.Limit, seg.Map.Offset))
+			}
+			fmt.Fprintf(bw, "#EXT-X-MAP:%s\n", attrs)
+			xmap = seg.Map
+		}
+
+		if seg.Discontinuity {
+			fmt.Fprintln(bw, "#EXT-X-DISCONTINUITY")
+		}
+		if !seg.ProgramDateTime.IsZero() {
+			fmt.Fprintf(bw, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.ProgramDateTime.Format(m3u8.DATETIME))
+		}
+		if seg.Bitrate != 0 && seg.Bitrate != bitrate {
+			fmt.Fprintf(bw, "#EXT-X-BITRATE:%d\n", seg.Bitrate)
+			bitrate = seg.Bitrate
+		}
+		if seg.Gap {
+			fmt.Fprintln(bw, "#EXT-X-GAP")
+		}
+
+		fmt.Fprintf(bw, "#EXTINF:%s,%s\n", formatFloat(seg.Duration), seg.Title)
+
+		if seg.Limit > 0 {
+			// § 4.3.2.2
+			// If o is not present, the sub-range begins at the next byte
+			// following the sub-range of the previous Media Segment.
+			if prev != nil && prev.URI == seg.URI && prev.Offset+prev.Limit == seg.Offset {
+				fmt.Fprintf(bw, "#EXT-X-BYTERANGE:%d\n", seg.Limit)
+			} else {
+				fmt.Fprintf(bw, "#EXT-X-BYTERANGE:%d@%d\n", seg.Limit, seg.Offset)
+			}
+		}
+
+		fmt.Fprintln(bw, uri(seg.URI))
+		prev = seg
+	}
+
+	if p.Closed {
+		fmt.Fprintln(bw, "#EXT-X-ENDLIST")
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,172 @@
+package hls
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func encode(t *testing.T, playlist m3u8.Playlist, rewrite func(string) string) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch p := playlist.(type) {
+	case *MasterPlaylist:
+		err = p.EncodeTo(&buf, rewrite)
+	case *MediaPlaylist:
+		err = p.EncodeTo(&buf, rewrite)
+	default:
+		t.Fatalf("unexpected playlist %T", playlist)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	fixtures := []struct {
+		name, playlist, uri string
+	}{
+		{"relative-master", relativeMasterPlaylist, "http://example.org/master.m3u8"},
+		{"relative-media", relativeMediaPlaylist, "http://example.org/media.m3u8"},
+		{"absolute-master", absoluteMasterPlaylist, "http://example.org/master.m3u8"},
+		{"absolute-media", absoluteMediaPlaylist, "http://example.org/media.m3u8"},
+		{"extra-tags", extraTagsPlaylist, ""},
+		{"renditions", renditionsPlaylist, ""},
+		{"session-tags", sessionTagsPlaylist, "http://example.org/master.m3u8"},
+		{"gap-and-bitrate", gapAndBitratePlaylist, "http://example.org/media.m3u8"},
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			playlist, _, err := parseM3U8(strings.NewReader(f.playlist), f.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded := encode(t, playlist, nil)
+
+			golden := filepath.Join("testdata", f.name+".m3u8")
+			if *update {
+				if err := ioutil.WriteFile(golden, encoded, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(encoded, expected) {
+				t.Errorf("encoded playlist does not match %s:\n%s", golden, encoded)
+			}
+
+			playlist, _, err = parseM3U8(bytes.NewReader(encoded), f.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reencoded := encode(t, playlist, nil); !bytes.Equal(encoded, reencoded) {
+				t.Errorf("parse→encode→parse is not stable:\n%s\nbecame\n%s", encoded, reencoded)
+			}
+		})
+	}
+}
+
+func TestEncodeRewrite(t *testing.T) {
+	playlist, _, err := parseM3U8(strings.NewReader(relativeMediaPlaylist), "http://example.org/media.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := encode(t, playlist, func(uri string) string {
+		return strings.TrimPrefix(uri, "http://example.org/")
+	})
+
+	expected := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:10
+#EXT-X-KEY:METHOD=AES-128,URI="key"
+#EXT-X-MAP:URI="map"
+#EXTINF:9,
+seg.ts
+`
+	if string(encoded) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, encoded)
+	}
+}
+
+func TestEncodeByterange(t *testing.T) {
+	playlist, _, err := parseM3U8(strings.NewReader(`
+		#EXTM3U
+		#EXT-X-VERSION:4
+		#EXT-X-TARGETDURATION:4
+		#EXT-X-BYTERANGE:1000@0
+		#EXTINF:3.14,
+		video.ts
+		#EXT-X-BYTERANGE:1000
+		#EXTINF:3.14,
+		video.ts
+		#EXT-X-BYTERANGE:500@0
+		#EXTINF:3.14,
+		other.ts
+		#EXT-X-ENDLIST
+	`), "http://example.org/media.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media := playlist.(*MediaPlaylist)
+	if media.Segments[1].Offset != 1000 {
+		t.Error("implicit EXT-X-BYTERANGE offset expected 1000, found", media.Segments[1].Offset)
+	}
+
+	expected := `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:4
+#EXTINF:3.14,
+#EXT-X-BYTERANGE:1000@0
+http://example.org/video.ts
+#EXTINF:3.14,
+#EXT-X-BYTERANGE:1000
+http://example.org/video.ts
+#EXTINF:3.14,
+#EXT-X-BYTERANGE:500@0
+http://example.org/other.ts
+#EXT-X-ENDLIST
+`
+	if encoded := encode(t, playlist, nil); string(encoded) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, encoded)
+	}
+}
+
+func TestEncodeStartAndRenditions(t *testing.T) {
+	playlist, _, err := parseM3U8(strings.NewReader(`
+		#EXTM3U
+		#EXT-X-START:TIME-OFFSET=0
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="en.m3u8"
+		#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",URI="subs.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+		video.m3u8
+	`), "http://example.org/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No variant refers to the subtitles, yet they are kept.
+	expected := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-START:TIME-OFFSET=0
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="http://example.org/en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",URI="http://example.org/subs.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+http://example.org/video.m3u8
+`
+	if encoded := encode(t, playlist, nil); string(encoded) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, encoded)
+	}
+}
@@ -0,0 +1,78 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+var (
+	// ErrPlaylistType is matched, with errors.Is, by every
+	// PlaylistTypeError.
+	ErrPlaylistType = errors.New("wrong type of playlist")
+
+	// ErrTargetDuration is matched, with errors.Is, by every
+	// TargetDurationError.
+	ErrTargetDuration = errors.New("invalid EXT-X-TARGETDURATION")
+
+	// ErrSegmentsExpired is matched, with errors.Is, by every
+	// SegmentsExpiredError.
+	ErrSegmentsExpired = errors.New("segments expired")
+
+	// ErrMalformedPlaylist is matched, with errors.Is, by the errors for a
+	// playlist, or its session data, that could not be decoded.
+	ErrMalformedPlaylist = errors.New("malformed playlist")
+)
+
+// PlaylistTypeError is returned when a Master Playlist was given where a
+// Media Playlist was expected, or the other way around.
+type PlaylistTypeError struct {
+	Expected m3u8.ListType
+}
+
+func (e PlaylistTypeError) Error() string {
+	if e.Expected == m3u8.MASTER {
+		return "expected Master Playlist"
+	}
+	return "expected Media Playlist"
+}
+
+func (e PlaylistTypeError) Is(target error) bool {
+	return target == ErrPlaylistType
+}
+
+// TargetDurationError is returned for a Media Playlist whose target duration
+// is not positive, or too long to wait for.
+type TargetDurationError struct {
+	TargetDuration time.Duration
+}
+
+func (e TargetDurationError) Error() string {
+	if e.TargetDuration <= 0 {
+		return "EXT-X-TARGETDURATION non-positive"
+	}
+	return "EXT-X-TARGETDURATION too long"
+}
+
+func (e TargetDurationError) Is(target error) bool {
+	return target == ErrTargetDuration
+}
+
+// SegmentsExpiredError is returned, if the Client asks for it, when segments
+// were removed from a live Media Playlist before they could be downloaded.
+type SegmentsExpiredError struct {
+	// From is the Media Sequence Number of the first segment that expired,
+	// and Count how many did.
+	From  uint64
+	Count uint64
+}
+
+func (e SegmentsExpiredError) Error() string {
+	return fmt.Sprintf("%d segments expired, from %d", e.Count, e.From)
+}
+
+func (e SegmentsExpiredError) Is(target error) bool {
+	return target == ErrSegmentsExpired
+}
@@ -0,0 +1,98 @@
+package hls
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened during a download: a PlaylistLoaded,
+// SegmentStarted, SegmentFinished, SegmentsExpired, Retrying or Finished.
+type Event interface {
+	event()
+}
+
+// PlaylistLoaded is sent every time the Media Playlist is loaded.
+type PlaylistLoaded struct {
+	URI string
+
+	// Segments is how many segments the playlist has, and NewSegments how
+	// many of them are to be downloaded; NewDuration is their duration.
+	Segments    int
+	NewSegments int
+	NewDuration time.Duration
+
+	// Closed is set once the playlist has EXT-X-ENDLIST, like VOD
+	// playlists do from the start.  Nothing is added to it anymore, so the
+	// duration of what is left to download is NewDuration.
+	Closed bool
+}
+
+// SegmentStarted is sent when a segment is requested.
+type SegmentStarted struct {
+	SeqId    uint64
+	URI      string
+	Duration time.Duration
+}
+
+// SegmentFinished is sent when a segment has been written; Elapsed is the
+// time since it was requested.
+type SegmentFinished struct {
+	SeqId    uint64
+	URI      string
+	Bytes    int64
+	Duration time.Duration
+	Elapsed  time.Duration
+}
+
+// SegmentsExpired is sent when segments were removed from a live playlist
+// before they could be downloaded.
+type SegmentsExpired struct {
+	From  uint64
+	Count uint64
+}
+
+// Retrying is sent before a request is tried again, with the error of the
+// attempt before.
+type Retrying struct {
+	URI     string
+	Attempt int
+	Err     error
+}
+
+// Finished is the last event of a download, successful or not.
+type Finished struct {
+	Segments int
+	Bytes    int64
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (PlaylistLoaded) event()  {}
+func (SegmentStarted) event()  {}
+func (SegmentFinished) event() {}
+func (SegmentsExpired) event() {}
+func (Retrying) event()        {}
+func (Finished) event()        {}
+
+// emitter sends the events of a download to OnEvent one at a time, although
+// they happen in different goroutines.
+type emitter struct {
+	mu      sync.Mutex
+	onEvent func(Event)
+}
+
+func newEmitter(onEvent func(Event)) *emitter {
+	if onEvent == nil {
+		return nil
+	}
+	return &emitter{onEvent: onEvent}
+}
+
+func (e *emitter) emit(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onEvent(ev)
+}
@@ -0,0 +1,107 @@
+package hls
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// checkAbsolute fails t unless uri is absolute, as parseM3U8 resolves every
+// URI against that of the playlist.
+func checkAbsolute(t testing.TB, what, uri string) {
+	t.Helper()
+	if u, err := url.Parse(uri); err != nil || !u.IsAbs() {
+		t.Errorf("%s URI %q is not absolute", what, uri)
+	}
+}
+
+// checkPlaylist fails t if playlist breaks what parseM3U8 promises: absolute
+// URIs, and Media Segments numbered from the Media Sequence Number.
+func checkPlaylist(t testing.TB, playlist m3u8.Playlist) {
+	t.Helper()
+	switch p := playlist.(type) {
+	case *MasterPlaylist:
+		for _, v := range p.Variants {
+			checkAbsolute(t, "variant", v.URI)
+			for _, alt := range v.Alternatives {
+				if alt.URI != "" {
+					checkAbsolute(t, "rendition", alt.URI)
+				}
+			}
+		}
+		for _, data := range p.SessionData {
+			if data.URI != "" {
+				checkAbsolute(t, "session data", data.URI)
+			}
+		}
+		for _, key := range p.SessionKeys {
+			checkAbsolute(t, "session key", key.URI)
+		}
+
+	case *MediaPlaylist:
+		if len(p.Segments) != len(p.MediaPlaylist.Segments) {
+			t.Fatalf("%d segments, but m3u8 decoded %d", len(p.Segments), len(p.MediaPlaylist.Segments))
+		}
+		for i, seg := range p.Segments {
+			if seg.MediaSegment == nil || seg.MediaSegment != p.MediaPlaylist.Segments[i] {
+				t.Fatalf("segment %d does not match the one decoded by m3u8", i)
+			}
+			// § 4.3.3.2
+			// The Media Sequence Number of every other segment is equal to
+			// the Media Sequence Number of the segment that preceded it plus
+			// one.
+			if expected := p.SeqNo + uint64(i); seg.SeqId != expected {
+				t.Errorf("segment %d: expected SeqId %d, found %d", i, expected, seg.SeqId)
+			}
+			checkAbsolute(t, "segment", seg.URI)
+			if seg.Key != nil {
+				checkAbsolute(t, "key", seg.Key.URI)
+			}
+			if seg.Map != nil {
+				checkAbsolute(t, "map", seg.Map.URI)
+			}
+		}
+
+	default:
+		t.Fatalf("unexpected playlist %T", playlist)
+	}
+}
+
+// fuzzParse seeds f with the playlists of testdata, and fuzzes parseM3U8 with
+// them, checking the playlists of listType it returns.
+func fuzzParse(f *testing.F, listType m3u8.ListType) {
+	seeds := conformanceCorpus(f)
+	testdata, err := filepath.Glob(filepath.Join("testdata", "*.m3u8"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range append(seeds, testdata...) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if _, t, err := parseM3U8(bytes.NewReader(data), conformanceURI); err == nil && t == listType {
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		playlist, playlistType, err := parseM3U8(bytes.NewReader(data), conformanceURI)
+		if err != nil || playlistType != listType {
+			t.Skip()
+		}
+		checkPlaylist(t, playlist)
+	})
+}
+
+func FuzzParseMaster(f *testing.F) {
+	fuzzParse(f, m3u8.MASTER)
+}
+
+func FuzzParseMedia(f *testing.F) {
+	fuzzParse(f, m3u8.MEDIA)
+}
@@ -3,11 +3,13 @@ package hls
 import (
 	"bytes"
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafov/m3u8"
@@ -15,15 +17,95 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// StartPosition selects the segment a download begins with.
+type StartPosition int
+
+const (
+	// StartDefault begins at the segment given by EXT-X-START or, if there
+	// is none, at the first segment of the playlist.
+	StartDefault StartPosition = iota
+
+	// StartLiveEdge begins three target durations from the end of a live
+	// playlist.  VOD playlists are downloaded from the first segment.
+	StartLiveEdge
+
+	// StartDVR begins at the first segment of the playlist, that is, at the
+	// beginning of the DVR window of a live playlist.
+	StartDVR
+)
+
 type Client struct {
 	Client *http.Client
+
+	Start StartPosition
+
+	// FillGaps lists the Media Playlists of other variants, in order of
+	// preference.  A segment marked with EXT-X-GAP is replaced by the
+	// segment with the same Media Sequence Number from the first of them
+	// that has it; otherwise it is skipped.
+	FillGaps []string
+
+	// FailOnExpired makes a download of a live stream fail with a
+	// SegmentsExpiredError when segments expire before they are downloaded,
+	// instead of going on without them.
+	FailOnExpired bool
+
+	// OnEvent, if not nil, is called with the progress of a download, one
+	// event at a time.  It should return quickly, since the download waits
+	// for it.
+	OnEvent func(Event)
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+
+	// Clock, if not nil, is what playlists are reloaded and requests
+	// retried by, instead of the real time.
+	Clock dam.Clock
+
+	events *emitter
 }
 
-func sleep(ctx context.Context, d time.Duration) {
-	ctx, cancel := context.WithTimeout(ctx, d)
-	<-ctx.Done()
-	cancel()
-	return
+// Report summarizes what was downloaded.
+type Report struct {
+	Segments int
+	Bytes    int64
+	Gaps     []Gap
+}
+
+// Gap is a segment marked with EXT-X-GAP.  FilledFrom is the Media Playlist
+// the segment was taken from instead, or empty if it was skipped.
+type Gap struct {
+	SeqId      uint64
+	URI        string
+	Duration   time.Duration
+	FilledFrom string
+}
+
+func init() {
+	dam.Register(dam.Protocol{
+		Name:       "hls",
+		Extensions: []string{".m3u8", ".m3u"},
+		ContentTypes: []string{
+			"application/vnd.apple.mpegurl",
+			"application/x-mpegurl",
+			"audio/mpegurl",
+			"audio/x-mpegurl",
+		},
+		Sniff: func(prefix []byte) bool {
+			return bytes.HasPrefix(prefix, []byte("#EXTM3U"))
+		},
+		New: func(client *http.Client) dam.Downloader {
+			return &Client{Client: client}
+		},
+	})
+}
+
+func (h Client) logger() *slog.Logger {
+	return dam.Logger(h.Logger)
+}
+
+func (h Client) clock() dam.Clock {
+	return dam.ClockOrReal(h.Clock)
 }
 
 type readCloserWithCancel struct {
@@ -52,23 +134,137 @@ func (h Client) readPlaylist(ctx context.Context, uri string) (m3u8.Playlist, m3
 	}
 
 	r.Body = readCloserWithCancel{r.Body, cancel}
+	defer r.Body.Close()
 	if r.StatusCode != 200 {
-		return nil, 0, dam.HTTPError{r}
+		return nil, 0, dam.NewHTTPError(r)
 	}
-	defer r.Body.Close()
 
 	return parseM3U8(r.Body, uri)
 }
 
-func (h Client) ListVariants(uri string) ([]*m3u8.Variant, error) {
-	playlist, playlistType, err := h.readPlaylist(context.TODO(), uri)
+func (h Client) ReadMasterPlaylist(ctx context.Context, uri string) (*MasterPlaylist, error) {
+	playlist, playlistType, err := h.readPlaylist(ctx, uri)
 	if err != nil {
 		return nil, err
 	} else if playlistType != m3u8.MASTER {
-		return nil, errors.New("expected Master Playlist")
+		return nil, PlaylistTypeError{Expected: m3u8.MASTER}
+	}
+
+	return playlist.(*MasterPlaylist), nil
+}
+
+func (h Client) ListVariants(uri string) ([]*m3u8.Variant, error) {
+	master, err := h.ReadMasterPlaylist(context.TODO(), uri)
+	if err != nil {
+		return nil, err
 	}
 
-	return playlist.(*MasterPlaylist).Variants, nil
+	return master.Variants, nil
+}
+
+// ListFormats lists the variants of the Master Playlist at uri, leaving out
+// I-frame variants.  A Media Playlist is listed as a single format.
+func (h Client) ListFormats(ctx context.Context, uri string) ([]dam.Format, error) {
+	playlist, playlistType, err := h.readPlaylist(ctx, uri)
+	if err != nil {
+		return nil, err
+	} else if playlistType == m3u8.MEDIA {
+		return []dam.Format{{ID: "0", URI: uri}}, nil
+	}
+
+	var formats []dam.Format
+	for i, v := range playlist.(*MasterPlaylist).Variants {
+		if v.Iframe {
+			continue
+		}
+
+		f := dam.Format{
+			ID:        strconv.Itoa(i),
+			URI:       v.URI,
+			Bandwidth: uint64(v.Bandwidth),
+			Codecs:    v.Codecs,
+		}
+		fmt.Sscanf(v.Resolution, "%dx%d", &f.Width, &f.Height)
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// DownloadFormat downloads the Media Playlist of format; uri is not needed.
+func (h Client) DownloadFormat(ctx context.Context, uri string, format dam.Format, dst io.Writer, progress func(dam.Progress)) error {
+	_, err := h.download(ctx, format.URI, ConcatSink(dst), progress)
+	return err
+}
+
+// Describe tells the title of the presentation, from an EXT-X-SESSION-DATA
+// of the Master Playlist at uri whose DATA-ID ends in ".title", and when it
+// began, from the EXT-X-PROGRAM-DATE-TIME of the first segment of format.
+func (h Client) Describe(ctx context.Context, uri string, format dam.Format, fields *dam.Fields) error {
+	if uri != format.URI {
+		master, err := h.ReadMasterPlaylist(ctx, uri)
+		if err != nil {
+			return err
+		}
+		for _, data := range master.SessionData {
+			if data.Value != "" && strings.HasSuffix(data.DataID, ".title") {
+				fields.Title = data.Value
+				break
+			}
+		}
+	}
+
+	media, err := h.readMediaPlaylist(ctx, format.URI)
+	if err != nil {
+		return err
+	}
+	fields.Ext = "ts"
+	if len(media.Segments) > 0 {
+		seg := media.Segments[0]
+		if !seg.ProgramDateTime.IsZero() {
+			fields.Time = seg.ProgramDateTime
+		}
+		// § 4.3.2.5: EXT-X-MAP is how fragmented MP4 segments are initialized
+		if seg.Map != nil {
+			fields.Ext = "mp4"
+		}
+	}
+	return nil
+}
+
+// ReadSessionData returns the value of an EXT-X-SESSION-DATA tag as JSON,
+// fetching it if the tag has a URI.
+func (h Client) ReadSessionData(ctx context.Context, data *SessionData) (json.RawMessage, error) {
+	if data.URI == "" {
+		return json.Marshal(data.Value)
+	}
+
+	req, err := http.NewRequest("GET", data.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+
+	r, err := h.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		return nil, dam.NewHTTPError(r)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r.Body); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("%w: EXT-X-SESSION-DATA is not JSON", ErrMalformedPlaylist)
+	}
+
+	return json.RawMessage(buf.Bytes()), nil
 }
 
 func (h Client) readMediaPlaylist(ctx context.Context, uri string) (*MediaPlaylist, error) {
@@ -76,134 +272,329 @@ func (h Client) readMediaPlaylist(ctx context.Context, uri string) (*MediaPlayli
 	if err != nil {
 		return nil, err
 	} else if playlistType != m3u8.MEDIA {
-		return nil, errors.New("expected Media Playlist")
+		return nil, PlaylistTypeError{Expected: m3u8.MEDIA}
 	}
 
 	return playlist.(*MediaPlaylist), nil
 }
 
-func (h Client) Download(ctx context.Context, uri string, dst io.Writer) error {
-	g, ctx := errgroup.WithContext(ctx)
+// startSequence returns the Media Sequence Number of the segment a download
+// of media begins with.
+func startSequence(media *MediaPlaylist, start StartPosition) uint64 {
+	if len(media.Segments) == 0 {
+		return media.SeqNo
+	}
 
-	segDataCh := make(chan io.ReadCloser)
-	g.Go(func() error {
-		defer close(segDataCh)
+	var offset time.Duration
+	switch start {
+	case StartDefault:
+		offset = media.StartOffset
+	case StartLiveEdge:
+		if !media.Closed {
+			// § 6.3.3
+			// the client SHOULD NOT choose a segment that starts less than
+			// three target durations from the end of the Playlist file.
+			offset = -3 * media.TargetDuration
+		}
+	}
 
-		var nextMediaSequence uint64
-		byterangeOffsets := make(map[string]int64)
+	var total time.Duration
+	for _, seg := range media.Segments {
+		total += time.Duration(seg.Duration * 1e9)
+	}
 
-		for {
-			log.Println("[DEBUG] downloading playlist", uri)
+	// § 4.3.5.2
+	// A negative number indicates a negative time offset from the end of
+	// the last Media Segment in the Playlist.  If the absolute value of
+	// TIME-OFFSET exceeds the duration of the Playlist, it indicates either
+	// the end of the Playlist (if positive) or the beginning of the Playlist
+	// (if negative).
+	if offset < 0 {
+		offset += total
+	}
+	if offset <= 0 {
+		return media.Segments[0].SeqId
+	}
 
-			lastLoadedPlaylist := time.Now()
-			media, err := h.readMediaPlaylist(ctx, uri)
-			if err != nil {
-				return err
-			}
+	var elapsed time.Duration
+	for _, seg := range media.Segments {
+		elapsed += time.Duration(seg.Duration * 1e9)
+		if elapsed > offset {
+			return seg.SeqId
+		}
+	}
+	return media.Segments[len(media.Segments)-1].SeqId
+}
 
-			if media.Iframe {
-				return errors.New("EXT-I-FRAMES-ONLY not supported")
+func (h Client) fillGap(ctx context.Context, gap *MediaSegment) (*MediaSegment, string, error) {
+	for _, uri := range h.FillGaps {
+		media, err := h.readMediaPlaylist(ctx, uri)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
 			}
+			h.logger().Warn("cannot fill gap", "uri", gap.URI, "seq", gap.SeqId, "from", uri, "err", err)
+			continue
+		}
 
-			if media.TargetDuration <= 0 {
-				return errors.New("EXT-X-TARGETDURATION non-positive")
-			} else if media.TargetDuration >= 90*time.Second {
-				return errors.New("EXT-X-TARGETDURATION too long")
+		for _, seg := range media.Segments {
+			if seg.SeqId == gap.SeqId && !seg.Gap {
+				return seg, uri, nil
 			}
+		}
+	}
+	return nil, "", nil
+}
 
-			for _, seg := range media.Segments {
-				if seg.SeqId < nextMediaSequence {
-					log.Println("[DEBUG] skipping segment", seg.URI)
-					continue
-				} else if seg.SeqId > nextMediaSequence {
-					log.Println("[WARN]", seg.SeqId-nextMediaSequence, "segments expired")
+// fetch requests the resource at uri or, if limit is positive, the sub-range
+// of it given by limit and offset.  Failed requests are retried for as long
+// as timeout.
+func (h Client) fetch(ctx context.Context, uri string, limit, offset int64, timeout time.Duration) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	attempt := func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		if limit > 0 {
+			// the Range header is inclusive
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+limit-1))
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		r, err := h.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		r.Body = readCloserWithCancel{r.Body, cancel}
+		if limit > 0 && r.StatusCode == 200 {
+			r.Body.Close()
+			return dam.StopRetrying(fmt.Errorf("EXT-X-BYTERANGE: %w", dam.ErrByterangeUnsupported))
+		} else if (limit > 0 && r.StatusCode != 206) || (limit <= 0 && r.StatusCode != 200) {
+			defer r.Body.Close()
+			return dam.RetryStatus(r)
+		}
+
+		body = r.Body
+		return nil
+	}
+
+	err := dam.RetryClock(ctx, h.clock(), timeout, attempt, func(attempt int, err error) {
+		h.logger().Warn("retrying", "uri", uri, "attempt", attempt, "err", err)
+		h.events.emit(Retrying{URI: uri, Attempt: attempt, Err: err})
+	})
+	return body, err
+}
+
+// follow loads the Media Playlist at uri, and keeps reloading it for as long
+// as it is live, calling f with every segment that was not seen before, in
+// order.  Gaps that cannot be filled are recorded in report and skipped.
+func (h Client) follow(ctx context.Context, uri string, report *Report, f func(*MediaPlaylist, *MediaSegment) error) error {
+	var nextMediaSequence uint64
+	var started bool
+	byterangeOffsets := make(map[string]int64)
+
+	for {
+		h.logger().Debug("downloading playlist", "uri", uri)
+
+		lastLoadedPlaylist := h.clock().Now()
+		media, err := h.readMediaPlaylist(ctx, uri)
+		if err != nil {
+			return err
+		}
+
+		if media.Iframe {
+			return dam.NotSupportedError{Feature: "EXT-X-I-FRAMES-ONLY"}
+		}
+
+		if media.TargetDuration <= 0 || media.TargetDuration >= 90*time.Second {
+			return TargetDurationError{TargetDuration: media.TargetDuration}
+		}
+
+		if !started {
+			nextMediaSequence = startSequence(media, h.Start)
+			started = true
+		}
+
+		loaded := PlaylistLoaded{URI: uri, Segments: len(media.Segments), Closed: media.Closed}
+		for _, seg := range media.Segments {
+			if seg.SeqId >= nextMediaSequence {
+				loaded.NewSegments++
+				loaded.NewDuration += time.Duration(seg.Duration * 1e9)
+			}
+		}
+		h.events.emit(loaded)
+
+		for _, seg := range media.Segments {
+			if seg.SeqId < nextMediaSequence {
+				h.logger().Debug("skipping segment", "uri", seg.URI, "seq", seg.SeqId)
+				continue
+			} else if seg.SeqId > nextMediaSequence {
+				expired := SegmentsExpiredError{
+					From:  nextMediaSequence,
+					Count: seg.SeqId - nextMediaSequence,
 				}
-				nextMediaSequence = seg.SeqId + 1
+				h.events.emit(SegmentsExpired{From: expired.From, Count: expired.Count})
+				if h.FailOnExpired {
+					return expired
+				}
+				h.logger().Warn("segments expired", "seq", expired.From, "count", expired.Count)
+			}
+			nextMediaSequence = seg.SeqId + 1
 
-				log.Println("[DEBUG] downloading segment", seg.URI)
-				req, err := http.NewRequest("GET", seg.URI, nil)
+			if seg.Gap {
+				fill, from, err := h.fillGap(ctx, seg)
 				if err != nil {
 					return err
 				}
 
-				if seg.Key != nil {
-					return errors.New("EXT-X-KEY not supported")
-				}
-				if seg.Map != nil {
-					return errors.New("EXT-X-MAP not supported")
+				report.Gaps = append(report.Gaps, Gap{
+					SeqId:      seg.SeqId,
+					URI:        seg.URI,
+					Duration:   time.Duration(seg.Duration * 1e9),
+					FilledFrom: from,
+				})
+				if fill == nil {
+					h.logger().Warn("skipping gap", "uri", seg.URI, "seq", seg.SeqId)
+					continue
 				}
+				h.logger().Debug("filling gap", "uri", seg.URI, "seq", seg.SeqId, "from", from)
+				seg = fill
+			}
 
-				if seg.Limit < 0 {
-					return errors.New("EXT-X-BYTERANGE is negative")
-				} else if seg.Limit > 0 {
-					offset, ok := byterangeOffsets[seg.URI]
-					if seg.Offset != 0 {
-						offset = seg.Offset
-					} else if !ok {
-						// We should be returning an error here saying that an
-						// offset was not given.  However, we can't
-						// differentiate between a missing and a zero offset so
-						// we'll just assume a zero offset was given.
-					}
-
-					// the Range header is inclusive
-					end := offset + seg.Limit - 1
-					req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
-					byterangeOffsets[seg.URI] = end + 1
+			if seg.Limit < 0 {
+				return fmt.Errorf("%w: EXT-X-BYTERANGE is negative", ErrMalformedPlaylist)
+			} else if seg.Limit > 0 {
+				if _, ok := byterangeOffsets[seg.URI]; seg.Offset == 0 && ok {
+					// We should be returning an error here saying that an
+					// offset was not given.  However, we can't differentiate
+					// between a missing and a zero offset so we'll just
+					// assume a zero offset was given unless we've seen a
+					// previous sub-range of this URI.
+					seg.Offset = byterangeOffsets[seg.URI]
 				}
+				byterangeOffsets[seg.URI] = seg.Offset + seg.Limit
+			}
+
+			if err := f(media, seg); err != nil {
+				return err
+			}
+		}
+
+		if media.Closed {
+			return nil
+		}
+
+		if loaded.NewSegments == 0 {
+			// § 6.3.4
+			// If the client reloads a Playlist file and finds that it has not
+			// changed, then it MUST wait for a period of one-half the target
+			// duration before retrying.
+			dam.Sleep(ctx, h.clock(), media.TargetDuration/2)
+
+		} else {
+			// § 6.3.4
+			// When a client loads a Playlist file for the first time or reloads a
+			// Playlist file and finds that it has changed since the last time it
+			// was loaded, the client MUST wait for at least the target duration
+			// before attempting to reload the Playlist file again, measured from
+			// the last time the client began loading the Playlist file.
+			dam.Sleep(ctx, h.clock(), lastLoadedPlaylist.Add(media.TargetDuration).Sub(h.clock().Now()))
+		}
+	}
+}
+
+func (h Client) Download(ctx context.Context, uri string, dst io.Writer) error {
+	_, err := h.DownloadReport(ctx, uri, dst)
+	return err
+}
+
+func (h Client) DownloadReport(ctx context.Context, uri string, dst io.Writer) (*Report, error) {
+	return h.download(ctx, uri, ConcatSink(dst), nil)
+}
+
+// DownloadSink downloads the Media Playlist at uri like Download, handing
+// the segments to sink instead of concatenating them.  Encrypted segments and
+// segments with an EXT-X-MAP are passed on, for sink to refuse or not; the
+// EXT-X-MAP is fetched only for a sink that keeps it, like a Relay.
+func (h Client) DownloadSink(ctx context.Context, uri string, sink SegmentSink) (*Report, error) {
+	return h.download(ctx, uri, sink, nil)
+}
+
+// segmentData is a segment being downloaded.
+type segmentData struct {
+	io.ReadCloser
+	seg     *MediaSegment
+	started time.Time
+}
 
-				ctx, cancel := context.WithTimeout(ctx, 2*media.TargetDuration)
-				segData, err := h.Client.Do(req.WithContext(ctx))
+func (h Client) download(ctx context.Context, uri string, sink SegmentSink, progress func(dam.Progress)) (*Report, error) {
+	started := h.clock().Now()
+	h.events = newEmitter(h.OnEvent)
+
+	report := new(Report)
+	g, ctx := errgroup.WithContext(ctx)
+
+	segDataCh := make(chan segmentData)
+	g.Go(func() error {
+		defer close(segDataCh)
+
+		return h.follow(ctx, uri, report, func(media *MediaPlaylist, seg *MediaSegment) error {
+			if r, ok := sink.(refuser); ok {
+				if err := r.refuse(segmentMeta(seg)); err != nil {
+					return err
+				}
+			}
+			if k, ok := sink.(mapKeeper); ok && seg.Map != nil && !k.hasMap(*seg.Map) {
+				h.logger().Debug("downloading map", "uri", seg.Map.URI)
+				r, err := h.fetch(ctx, seg.Map.URI, seg.Map.Limit, seg.Map.Offset, 2*media.TargetDuration)
 				if err != nil {
-					cancel()
 					return err
 				}
-
-				segData.Body = readCloserWithCancel{segData.Body, cancel}
-				if seg.Limit > 0 {
-					if segData.StatusCode == 200 {
-						return errors.New("EXT-X-BYTERANGE not supported by the server")
-					} else if segData.StatusCode != 206 {
-						return dam.HTTPError{segData}
-					}
-				} else if segData.StatusCode != 200 {
-					return dam.HTTPError{segData}
+				var buf bytes.Buffer
+				_, err = io.Copy(&buf, r)
+				r.Close()
+				if err != nil {
+					return err
 				}
-
-				select {
-				case segDataCh <- segData.Body:
-
-				case <-ctx.Done():
-					segData.Body.Close()
-					return ctx.Err()
+				if err := k.keepMap(*seg.Map, buf.Bytes()); err != nil {
+					return err
 				}
 			}
 
-			if media.Closed {
-				return nil
+			h.logger().Debug("downloading segment", "uri", seg.URI, "seq", seg.SeqId)
+			h.events.emit(SegmentStarted{
+				SeqId:    seg.SeqId,
+				URI:      seg.URI,
+				Duration: time.Duration(seg.Duration * 1e9),
+			})
+			segStarted := h.clock().Now()
+			r, err := h.fetch(ctx, seg.URI, seg.Limit, seg.Offset, 2*media.TargetDuration)
+			if err != nil {
+				return err
 			}
 
-			lastSegment := media.Segments[len(media.Segments)-1]
-			if lastSegment.SeqId < nextMediaSequence {
-				// § 6.3.4
-				// If the client reloads a Playlist file and finds that it has not
-				// changed, then it MUST wait for a period of one-half the target
-				// duration before retrying.
-				sleep(ctx, media.TargetDuration/2)
+			report.Segments++
+
+			select {
+			case segDataCh <- segmentData{r, seg, segStarted}:
+				return nil
 
-			} else {
-				// § 6.3.4
-				// When a client loads a Playlist file for the first time or reloads a
-				// Playlist file and finds that it has changed since the last time it
-				// was loaded, the client MUST wait for at least the target duration
-				// before attempting to reload the Playlist file again, measured from
-				// the last time the client began loading the Playlist file.
-				sleep(ctx, time.Until(lastLoadedPlaylist.Add(media.TargetDuration)))
+			case <-ctx.Done():
+				r.Close()
+				return ctx.Err()
 			}
-		}
+		})
 	})
 
+	// stopped is set if the reader of the sink went away, which is how a
+	// download piped into another program ends.
+	var stopped bool
 	g.Go(func() error {
+		var written int
 		for r := range segDataCh {
 			// TODO: limit the maximum buffer size
 			var buf bytes.Buffer
@@ -212,16 +603,54 @@ func (h Client) Download(ctx context.Context, uri string, dst io.Writer) error {
 				r.Close()
 				return err
 			}
-			if _, err := io.Copy(dst, &buf); err != nil {
+			if err := sink.BeginSegment(segmentMeta(r.seg)); err != nil {
 				r.Close()
 				return err
 			}
+			n, err := io.Copy(sink, &buf)
+			report.Bytes += n
+			if err != nil {
+				r.Close()
+				sink.EndSegment(err)
+				if dam.IsClosedPipe(err) {
+					h.logger().Info("output closed, stopping", "uri", uri, "err", err)
+					stopped = true
+				}
+				return err
+			}
 			if err := r.Close(); err != nil {
+				sink.EndSegment(err)
 				return err
 			}
+			if err := sink.EndSegment(nil); err != nil {
+				return err
+			}
+
+			written++
+			h.logger().Debug("wrote segment", "uri", r.seg.URI, "seq", r.seg.SeqId, "bytes", n)
+			h.events.emit(SegmentFinished{
+				SeqId:    r.seg.SeqId,
+				URI:      r.seg.URI,
+				Bytes:    n,
+				Duration: time.Duration(r.seg.Duration * 1e9),
+				Elapsed:  h.clock().Now().Sub(r.started),
+			})
+			if progress != nil {
+				progress(dam.Progress{Segments: written, Bytes: report.Bytes})
+			}
 		}
 		return nil
 	})
 
-	return g.Wait()
+	err := g.Wait()
+	if stopped {
+		err = nil
+	}
+	h.events.emit(Finished{
+		Segments: report.Segments,
+		Bytes:    report.Bytes,
+		Elapsed:  h.clock().Now().Sub(started),
+		Err:      err,
+	})
+	return report, err
 }
@@ -1,73 +1,124 @@
 package hls
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+	"github.com/otommod/go-dam/hls/hlstest"
 )
 
 func TestMediaSequence(t *testing.T) {
+	clock := hlstest.NewAutoClock(time.Now())
+	o := hlstest.NewOrigin(clock)
+	defer o.Close()
+	uri := o.AddStream("live", hlstest.Stream{Live: true, Segments: 3, Window: 2})
+
+	h := Client{
+		Client: o.Client(),
+		Clock:  clock,
+	}
+
+	var buf bytes.Buffer
+	err := h.Download(context.Background(), uri, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []byte
+	for seq := uint64(0); seq < 3; seq++ {
+		path := fmt.Sprintf("/live/%d.ts", seq)
+		if n := o.Requests(path); n != 1 {
+			t.Errorf("expected segment %s to be read once, found %d times", path, n)
+		}
+		expected = append(expected, o.Data("live", seq)...)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Error("expected the segments in order")
+	}
+}
+
+func TestReloadTiming(t *testing.T) {
 	mux := http.NewServeMux()
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
-	accessed := make(map[string]int)
+	var mu sync.Mutex
+	var loads int
 	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		loads++
+		n := loads
+		mu.Unlock()
+
 		w.WriteHeader(200)
 		io.WriteString(w, `
 			#EXTM3U
-			#EXT-X-VERSION:3
 			#EXT-X-TARGETDURATION:4
-			#EXTINF:3.14,
-			seg/1.ts
-			#EXTINF:3.14,
-			seg/2.ts
+			#EXTINF:4,
+			0.ts
 		`)
-
-		switch accessed[r.URL.EscapedPath()] {
-		case 1:
-			io.WriteString(w, `
-				#EXTINF:3.14,
-				seg/3.ts
-			`)
-		case 2:
-			io.WriteString(w, "#EXT-X-ENDLIST")
+		// the second load finds the playlist unchanged
+		if n > 2 {
+			io.WriteString(w, "#EXTINF:4,\n1.ts\n#EXT-X-ENDLIST\n")
 		}
-		accessed[r.URL.EscapedPath()]++
 	})
-
-	mux.HandleFunc("/seg/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
-		w.Write(make([]byte, 1000))
-		accessed[r.URL.EscapedPath()]++
 	})
 
+	start := time.Now()
+	clock := hlstest.NewAutoClock(start)
+	var loaded []time.Duration
 	h := Client{
 		Client: srv.Client(),
+		Clock:  clock,
+		OnEvent: func(ev Event) {
+			if _, ok := ev.(PlaylistLoaded); ok {
+				loaded = append(loaded, clock.Now().Sub(start))
+			}
+		},
 	}
-
-	err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard)
-	if err != nil {
+	if err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard); err != nil {
 		t.Fatal(err)
 	}
 
-	if accessed["/seg/1.ts"] < 1 {
-		t.Error("segment", "/seg/1.ts", "was not read at all")
-	} else if accessed["/seg/1.ts"] > 1 {
-		t.Error("segment", "/seg/1.ts", "was read more than once")
+	// § 6.3.4: a whole target duration after the first load, and half of it
+	// after a load that found nothing new
+	expected := []time.Duration{0, 4 * time.Second, 6 * time.Second}
+	if fmt.Sprint(loaded) != fmt.Sprint(expected) {
+		t.Errorf("expected loads at %v, found %v", expected, loaded)
 	}
-	if accessed["/seg/2.ts"] < 1 {
-		t.Error("segment", "/seg/2.ts", "was not read at all")
-	} else if accessed["/seg/2.ts"] > 1 {
-		t.Error("segment", "/seg/2.ts", "was read more than once")
+}
+
+func TestRetryAfter(t *testing.T) {
+	start := time.Now()
+	clock := hlstest.NewAutoClock(start)
+	o := hlstest.NewOrigin(clock)
+	defer o.Close()
+	uri := o.AddStream("vod", hlstest.Stream{Segments: 1})
+	o.Fail("/vod/0.ts", hlstest.Fault{Status: 503, RetryAfter: 3 * time.Second, Times: 2})
+
+	h := Client{Client: o.Client(), Clock: clock}
+	if err := h.Download(context.Background(), uri, ioutil.Discard); err != nil {
+		t.Fatal(err)
 	}
-	if accessed["/seg/3.ts"] < 1 {
-		t.Error("segment", "/seg/3.ts", "was not read at all")
-	} else if accessed["/seg/3.ts"] > 1 {
-		t.Error("segment", "/seg/3.ts", "was read more than once")
+	if n := o.Requests("/vod/0.ts"); n != 3 {
+		t.Errorf("expected 3 attempts, found %d", n)
+	}
+	if waited := clock.Now().Sub(start); waited != 6*time.Second {
+		t.Errorf("expected to wait 6s as asked by Retry-After, found %s", waited)
 	}
 }
 
@@ -93,55 +144,537 @@ func TestEncryption(t *testing.T) {
 	}
 
 	err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard)
-	if err.Error() == "EXT-X-KEY not supported" {
-		// TODO: add ErrNotSupported
-	} else if err != nil {
-		t.Fatal(err)
+	if !errors.Is(err, dam.ErrNotSupported) {
+		t.Fatalf("expected %v, found %v", dam.ErrNotSupported, err)
+	}
+	var notSupported dam.NotSupportedError
+	if !errors.As(err, &notSupported) || notSupported.Feature != "EXT-X-KEY" {
+		t.Errorf("expected EXT-X-KEY not to be supported, found %v", err)
 	}
 }
 
 func TestByterange(t *testing.T) {
+	o := hlstest.NewOrigin(nil)
+	defer o.Close()
+	uri := o.AddStream("video", hlstest.Stream{Segments: 2, Byterange: true})
+
+	h := Client{
+		Client: o.Client(),
+	}
+
+	var buf bytes.Buffer
+	err := h.Download(context.Background(), uri, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(o.Data("video", 0), o.Data("video", 1)...)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Error("expected the sub-ranges in order")
+	}
+	if n := o.Requests("/video/all.ts"); n != 2 {
+		t.Errorf("expected 2 requests for sub-ranges, found %d", n)
+	}
+}
+
+func TestErrors(t *testing.T) {
 	mux := http.NewServeMux()
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
-	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		io.WriteString(w, `
+	playlists := map[string]string{
+		"/master.m3u8": `
+			#EXTM3U
+			#EXT-X-STREAM-INF:BANDWIDTH=1280000
+			media.m3u8
+		`,
+		"/target-duration.m3u8": `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:100
+			#EXTINF:3.14,
+			seg.ts
+			#EXT-X-ENDLIST
+		`,
+		"/iframes.m3u8": `
 			#EXTM3U
 			#EXT-X-VERSION:4
 			#EXT-X-TARGETDURATION:4
+			#EXT-X-I-FRAMES-ONLY
+			#EXTINF:3.14,
 			#EXT-X-BYTERANGE:1000@0
+			seg.ts
+			#EXT-X-ENDLIST
+		`,
+		"/byterange.m3u8": `
+			#EXTM3U
+			#EXT-X-VERSION:4
+			#EXT-X-TARGETDURATION:4
 			#EXTINF:3.14,
-			video.ts
+			#EXT-X-BYTERANGE:1000@0
+			seg.ts
+			#EXT-X-ENDLIST
+		`,
+		"/negative-byterange.m3u8": `
+			#EXTM3U
+			#EXT-X-VERSION:4
+			#EXT-X-TARGETDURATION:4
 			#EXTINF:3.14,
-			#EXT-X-BYTERANGE:1000
-			video.ts
+			#EXT-X-BYTERANGE:-1000@0
+			seg.ts
+			#EXT-X-ENDLIST
+		`,
+		"/missing.m3u8": `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:3.14,
+			missing.ts
+			#EXT-X-ENDLIST
+		`,
+	}
+	for path, playlist := range playlists {
+		playlist := playlist
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			io.WriteString(w, playlist)
+		})
+	}
+
+	// ignores the Range header
+	mux.HandleFunc("/seg.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 2000))
+	})
+	mux.HandleFunc("/session.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, "not JSON")
+	})
+	var missingRequests int
+	mux.HandleFunc("/missing.ts", func(w http.ResponseWriter, r *http.Request) {
+		missingRequests++
+		w.WriteHeader(404)
+		io.WriteString(w, "no such segment")
+	})
+
+	tests := []struct {
+		path     string
+		expected error
+	}{
+		{"/master.m3u8", ErrPlaylistType},
+		{"/target-duration.m3u8", ErrTargetDuration},
+		{"/iframes.m3u8", dam.ErrNotSupported},
+		{"/byterange.m3u8", dam.ErrByterangeUnsupported},
+		{"/negative-byterange.m3u8", ErrMalformedPlaylist},
+	}
+
+	h := Client{
+		Client: srv.Client(),
+		Clock:  hlstest.NewAutoClock(time.Now()),
+	}
+
+	for _, test := range tests {
+		err := h.Download(context.Background(), srv.URL+test.path, ioutil.Discard)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, found %v", test.path, test.expected, err)
+		}
+	}
+
+	_, err := h.ReadSessionData(context.Background(), &SessionData{URI: srv.URL + "/session.txt"})
+	if !errors.Is(err, ErrMalformedPlaylist) {
+		t.Errorf("expected %v for session data that is not JSON, found %v", ErrMalformedPlaylist, err)
+	}
+
+	err = h.Download(context.Background(), srv.URL+"/missing.m3u8", ioutil.Discard)
+	var httpErr dam.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected an HTTPError, found %v", err)
+	}
+	if httpErr.StatusCode != 404 || httpErr.URL != srv.URL+"/missing.ts" || string(httpErr.Body) != "no such segment" {
+		t.Errorf("expected a 404 for %s saying %q, found %v saying %q",
+			srv.URL+"/missing.ts", "no such segment", httpErr, httpErr.Body)
+	}
+	if missingRequests != 1 || httpErr.Attempt != 0 {
+		t.Errorf("expected a missing segment not to be retried, found %d requests", missingRequests)
+	}
+}
+
+func TestSegmentsExpired(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var reloads int
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		mediaSequence := 0
+		if reloads > 0 {
+			mediaSequence = 5
+		}
+		reloads++
+		fmt.Fprintf(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:1
+			#EXT-X-MEDIA-SEQUENCE:%d
+			#EXTINF:1,
+			seg.ts
+			#EXTINF:1,
+			seg.ts
+		`, mediaSequence)
+	})
+	mux.HandleFunc("/seg.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	h := Client{
+		Client:        srv.Client(),
+		Start:         StartDVR,
+		FailOnExpired: true,
+		Clock:         hlstest.NewAutoClock(time.Now()),
+	}
+
+	err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard)
+	var expired SegmentsExpiredError
+	if !errors.As(err, &expired) || !errors.Is(err, ErrSegmentsExpired) {
+		t.Fatalf("expected %v, found %v", ErrSegmentsExpired, err)
+	}
+	if expired.From != 2 || expired.Count != 3 {
+		t.Errorf("expected segments 2 to 4 to expire, found %v", expired)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:3.5,
+			0.ts
+			#EXTINF:3.5,
+			1.ts
 			#EXT-X-ENDLIST
 		`)
 	})
 
-	var mediaSequence uint64
-	mux.HandleFunc("/video.ts", func(w http.ResponseWriter, r *http.Request) {
-		httpRange := r.Header.Get("Range")
-		switch {
-		case mediaSequence == 0 && httpRange == "bytes=0-999":
-		case mediaSequence == 1 && httpRange == "bytes=1000-1999":
+	var failed bool
+	mux.HandleFunc("/0.ts", func(w http.ResponseWriter, r *http.Request) {
+		if !failed {
+			failed = true
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+		w.Write(make([]byte, 100))
+	})
+	mux.HandleFunc("/1.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 200))
+	})
+
+	var events []Event
+	h := Client{
+		Client: srv.Client(),
+		Clock:  hlstest.NewAutoClock(time.Now()),
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	}
+
+	if err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 7 {
+		t.Fatalf("expected 7 events, found %d: %v", len(events), events)
+	}
+
+	loaded, ok := events[0].(PlaylistLoaded)
+	if !ok || loaded.Segments != 2 || loaded.NewSegments != 2 || loaded.NewDuration != 7*time.Second || !loaded.Closed {
+		t.Errorf("expected a closed playlist of 2 new segments of 7s, found %+v", events[0])
+	}
+
+	finished, ok := events[6].(Finished)
+	if !ok || finished.Segments != 2 || finished.Bytes != 300 || finished.Err != nil {
+		t.Errorf("expected 2 segments of 300 bytes to be finished, found %+v", events[6])
+	}
+
+	var started, retried int
+	var bytes int64
+	for _, ev := range events[1:6] {
+		switch ev := ev.(type) {
+		case SegmentStarted:
+			started++
+		case SegmentFinished:
+			bytes += ev.Bytes
+		case Retrying:
+			retried++
+			if ev.URI != srv.URL+"/0.ts" || ev.Attempt != 2 {
+				t.Errorf("expected the second attempt at %s, found %+v", srv.URL+"/0.ts", ev)
+			}
 		default:
-			t.Error("requested wrong range", httpRange)
+			t.Errorf("unexpected event %+v", ev)
 		}
+	}
+	if started != 2 || retried != 1 || bytes != 300 {
+		t.Errorf("expected 2 segments of 300 bytes and a retry, found %d segments of %d bytes and %d retries",
+			started, bytes, retried)
+	}
+}
 
-		w.WriteHeader(206)
-		w.Write(make([]byte, 1000))
-		mediaSequence++ // FIXME: this is not thread safe
+func TestLogger(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXT-X-MEDIA-SEQUENCE:7
+			#EXTINF:3.5,
+			seg.ts
+			#EXT-X-ENDLIST
+		`)
+	})
+	mux.HandleFunc("/seg.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 100))
 	})
 
+	var buf bytes.Buffer
 	h := Client{
 		Client: srv.Client(),
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
 	}
 
-	err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard)
+	if err := h.Download(context.Background(), srv.URL+"/media.m3u8", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`msg="wrote segment" uri=%s/seg.ts seq=7 bytes=100`, srv.URL)
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected a record with %s, found\n%s", expected, buf.String())
+	}
+}
+
+func TestClosedPipe(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:4,
+			seg.ts
+			#EXTINF:4,
+			seg.ts
+			#EXTINF:4,
+			seg.ts
+			#EXT-X-ENDLIST
+		`)
+	})
+	mux.HandleFunc("/seg.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 100))
+	})
+
+	// the reader goes away after the first segment
+	pr, pw := io.Pipe()
+	go func() {
+		io.ReadFull(pr, make([]byte, 100))
+		pr.Close()
+	}()
+
+	var finished Finished
+	h := Client{Client: srv.Client(), OnEvent: func(ev Event) {
+		if f, ok := ev.(Finished); ok {
+			finished = f
+		}
+	}}
+	if err := h.Download(context.Background(), srv.URL+"/media.m3u8", pw); err != nil {
+		t.Fatalf("expected a closed pipe to stop the download cleanly, found %v", err)
+	}
+	if finished.Bytes >= 300 || finished.Err != nil {
+		t.Errorf("expected the download to stop early, found %+v", finished)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="Evening News"
+			#EXT-X-STREAM-INF:BANDWIDTH=1280000,RESOLUTION=1280x720
+			media.m3u8
+		`)
+	})
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-VERSION:6
+			#EXT-X-TARGETDURATION:4
+			#EXT-X-MAP:URI="init.mp4"
+			#EXT-X-PROGRAM-DATE-TIME:2020-03-04T19:00:00Z
+			#EXTINF:4,
+			seg.m4s
+		`)
+	})
+
+	h := Client{Client: srv.Client()}
+	formats, err := h.ListFormats(context.Background(), srv.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := dam.Describe(context.Background(), h, srv.URL+"/master.m3u8", formats[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := dam.Template("{date}/{title}-{height}p.{ext}").Expand(fields)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if expected := "2020-03-04/Evening News-720p.mp4"; name != expected {
+		t.Errorf("expected %q, found %q", expected, name)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/high.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-VERSION:8
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:3.14,
+			high/1.ts
+			#EXT-X-GAP
+			#EXTINF:3.14,
+			high/2.ts
+			#EXT-X-GAP
+			#EXTINF:3.14,
+			high/3.ts
+			#EXT-X-ENDLIST
+		`)
+	})
+
+	mux.HandleFunc("/low.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-VERSION:8
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:3.14,
+			low/1.ts
+			#EXTINF:3.14,
+			low/2.ts
+			#EXT-X-GAP
+			#EXTINF:3.14,
+			low/3.ts
+			#EXT-X-ENDLIST
+		`)
+	})
+
+	mux.HandleFunc("/high/1.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 1000))
+	})
+	mux.HandleFunc("/low/2.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(make([]byte, 100))
+	})
+
+	h := Client{
+		Client:   srv.Client(),
+		FillGaps: []string{srv.URL + "/low.m3u8"},
+	}
+
+	report, err := h.DownloadReport(context.Background(), srv.URL+"/high.m3u8", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Segments != 2 || report.Bytes != 1100 {
+		t.Error("expected 2 segments of 1100 bytes, found", report.Segments, "of", report.Bytes)
+	}
+
+	if len(report.Gaps) != 2 {
+		t.Fatal("expected 2 gaps, found", len(report.Gaps))
+	}
+	if report.Gaps[0].SeqId != 1 || report.Gaps[0].FilledFrom != srv.URL+"/low.m3u8" {
+		t.Error("gap 1 was not filled from the other variant", report.Gaps[0])
+	}
+	if report.Gaps[1].SeqId != 2 || report.Gaps[1].FilledFrom != "" {
+		t.Error("gap 2 should have been skipped", report.Gaps[1])
+	}
+}
+
+func TestStartSequence(t *testing.T) {
+	parse := func(s string) *MediaPlaylist {
+		playlist, _, err := parseM3U8(strings.NewReader(s), "http://example.org/media.m3u8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return playlist.(*MediaPlaylist)
+	}
+
+	segments := `
+		#EXTINF:4.0,
+		1.ts
+		#EXTINF:4.0,
+		2.ts
+		#EXTINF:4.0,
+		3.ts
+		#EXTINF:4.0,
+		4.ts
+		#EXTINF:4.0,
+		5.ts
+		#EXTINF:4.0,
+		6.ts
+	`
+
+	tests := []struct {
+		name     string
+		playlist string
+		start    StartPosition
+		expected uint64
+	}{
+		{"no EXT-X-START", "", StartDefault, 10},
+		{"positive offset", "#EXT-X-START:TIME-OFFSET=9", StartDefault, 12},
+		{"negative offset", "#EXT-X-START:TIME-OFFSET=-8.5", StartDefault, 13},
+		{"offset past the end", "#EXT-X-START:TIME-OFFSET=100", StartDefault, 15},
+		{"offset before the beginning", "#EXT-X-START:TIME-OFFSET=-100", StartDefault, 10},
+		{"live edge", "", StartLiveEdge, 13},
+		{"live edge of VOD", "#EXT-X-ENDLIST", StartLiveEdge, 10},
+		{"DVR window", "#EXT-X-START:TIME-OFFSET=-4", StartDVR, 10},
+	}
+
+	for _, test := range tests {
+		media := parse(`
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXT-X-MEDIA-SEQUENCE:10
+		` + test.playlist + segments)
+
+		if actual := startSequence(media, test.start); actual != test.expected {
+			t.Error(test.name, "expected", test.expected, "found", actual)
+		}
+	}
 }
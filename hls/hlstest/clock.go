@@ -0,0 +1,72 @@
+package hlstest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock, whose time only moves when it is advanced.  It is a
+// dam.Clock, so that a client can share it with an Origin.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	auto   bool
+	timers []timer
+}
+
+type timer struct {
+	when time.Time
+	c    chan time.Time
+}
+
+// NewClock returns a Clock that is stopped at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// NewAutoClock returns a Clock that starts at now and jumps forward to the
+// end of whatever is waited for as soon as it is, so that waits take no
+// time at all.
+func NewAutoClock(now time.Time) *Clock {
+	return &Clock{now: now, auto: true}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After is like time.After, on the time of c.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := timer{c.now.Add(d), make(chan time.Time, 1)}
+	if c.auto && t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.timers = append(c.timers, t)
+	c.fireLocked()
+	return t.c
+}
+
+// Advance moves the clock forward by d, firing the timers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fireLocked()
+}
+
+func (c *Clock) fireLocked() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = pending
+}
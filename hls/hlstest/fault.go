@@ -0,0 +1,105 @@
+package hlstest
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Fault is a failure that an Origin injects in its responses.
+type Fault struct {
+	// Status, if not zero, is answered instead of the resource, like 404 or
+	// 503.
+	Status int
+
+	// RetryAfter, if not zero, is sent in a Retry-After header along with
+	// Status.
+	RetryAfter time.Duration
+
+	// Delay slows the body down; it is spread over it, in real time.
+	Delay time.Duration
+
+	// Truncate, if not zero, cuts the body after that many bytes, although
+	// its whole length is announced.
+	Truncate int
+
+	// Times is how many requests fail; zero means all of them.
+	Times int
+}
+
+type fault struct {
+	Fault
+	pattern string
+}
+
+// Fail makes the requests whose path matches pattern, as in path.Match, fail
+// with f.  Faults are matched in the order they were added.
+func (o *Origin) Fail(pattern string, f Fault) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.faults = append(o.faults, &fault{f, pattern})
+}
+
+// takeFault returns the fault that the request for p is to fail with, if
+// any.
+func (o *Origin) takeFault(p string) *Fault {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, f := range o.faults {
+		if ok, _ := path.Match(f.pattern, p); !ok {
+			continue
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				o.faults = append(o.faults[:i], o.faults[i+1:]...)
+			}
+		}
+		taken := f.Fault
+		return &taken
+	}
+	return nil
+}
+
+// respond answers with the Status of f instead of the resource, and reports
+// whether it did.
+func (f *Fault) respond(w http.ResponseWriter) bool {
+	if f == nil || f.Status == 0 {
+		return false
+	}
+	if f.RetryAfter > 0 {
+		seconds := (f.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+	http.Error(w, http.StatusText(f.Status), f.Status)
+	return true
+}
+
+// write writes body to w, slowed down or truncated by f if it is not nil.
+func (f *Fault) write(w http.ResponseWriter, body []byte) {
+	if f == nil {
+		w.Write(body)
+		return
+	}
+	if f.Truncate > 0 && f.Truncate < len(body) {
+		body = body[:f.Truncate]
+	}
+
+	const chunks = 10
+	chunkSize := (len(body) + chunks - 1) / chunks
+	for len(body) > 0 {
+		if f.Delay > 0 {
+			time.Sleep(f.Delay / chunks)
+		}
+		n := chunkSize
+		if n > len(body) {
+			n = len(body)
+		}
+		w.Write(body[:n])
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		body = body[n:]
+	}
+}
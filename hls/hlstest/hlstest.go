@@ -0,0 +1,453 @@
+// Package hlstest provides a fake HLS origin server for tests, that serves
+// VOD and live streams, slides the window of live streams over time, and
+// fails on demand.
+package hlstest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is the key that encrypted streams are encrypted with, with
+// METHOD=AES-128 and the Media Sequence Number as IV.
+var Key = []byte("hlstest-aes-key!")
+
+// Stream describes a Media Playlist served by an Origin.  The Media Sequence
+// Numbers of its segments begin at zero.
+type Stream struct {
+	// Segments is how many segments there are.  Zero means, for a live
+	// stream, one that never ends, and for a VOD stream one segment.
+	Segments int
+
+	// Duration is the duration of each segment; zero means 4 seconds.
+	Duration time.Duration
+
+	// Size is the size of each segment, before encryption; zero means 1000
+	// bytes.
+	Size int
+
+	// Live streams are published a segment every Duration, starting with
+	// Window segments, of which the playlist lists the last Window.  When
+	// the last one is published, EXT-X-ENDLIST is added.
+	Live bool
+
+	// Window is how many segments a live playlist lists; zero means 3.
+	Window int
+
+	// Byterange serves the segments as sub-ranges of a single file, with
+	// EXT-X-BYTERANGE.
+	Byterange bool
+
+	// Encrypted encrypts the segments with Key, with EXT-X-KEY.
+	Encrypted bool
+
+	// Map gives the segments an initialization section, with EXT-X-MAP.
+	Map bool
+
+	// Discontinuities are the segments that begin with an
+	// EXT-X-DISCONTINUITY.
+	Discontinuities []uint64
+
+	// ProgramDateTime, if not zero, is the date and time of the first
+	// segment, given with EXT-X-PROGRAM-DATE-TIME.
+	ProgramDateTime time.Time
+
+	// Parts, if not zero, splits each segment in that many LL-HLS parts,
+	// with EXT-X-PART; parts of a live segment are published before the
+	// segment is.  Parts are never encrypted.
+	Parts int
+
+	// Bandwidth and Resolution describe the stream in the Master Playlist.
+	// Zero Bandwidth is worked out from Size and Duration.
+	Bandwidth  int
+	Resolution string
+
+	name    string
+	started time.Time
+}
+
+func (s *Stream) duration() time.Duration {
+	if s.Duration <= 0 {
+		return 4 * time.Second
+	}
+	return s.Duration
+}
+
+func (s *Stream) size() int {
+	if s.Size <= 0 {
+		return 1000
+	}
+	return s.Size
+}
+
+func (s *Stream) window() int {
+	if s.Window <= 0 {
+		return 3
+	}
+	return s.Window
+}
+
+// Origin is an HTTP server of fake streams.  Its Master Playlist is at
+// /master.m3u8 and lists every stream; the Media Playlist of a stream named
+// name is at /name/index.m3u8, next to its segments.
+type Origin struct {
+	*httptest.Server
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	streams  map[string]*Stream
+	names    []string
+	faults   []*fault
+	requests map[string]int
+}
+
+// NewOrigin starts an Origin, which the caller should Close.  Live streams
+// move on by clock, or by the real time if clock is nil.
+func NewOrigin(clock *Clock) *Origin {
+	o := &Origin{
+		now:      time.Now,
+		streams:  make(map[string]*Stream),
+		requests: make(map[string]int),
+	}
+	if clock != nil {
+		o.now = clock.Now
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serveHTTP))
+	return o
+}
+
+// AddStream serves s as name, and returns the URL of its Media Playlist.  A
+// live stream starts now.
+func (o *Origin) AddStream(name string, s Stream) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s.name, s.started = name, o.now()
+	if _, ok := o.streams[name]; !ok {
+		o.names = append(o.names, name)
+	}
+	o.streams[name] = &s
+	return o.URL + "/" + name + "/index.m3u8"
+}
+
+// MasterURL returns the URL of the Master Playlist.
+func (o *Origin) MasterURL() string {
+	return o.URL + "/master.m3u8"
+}
+
+// Requests tells how many times path was requested.
+func (o *Origin) Requests(path string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[path]
+}
+
+// data returns size bytes of data for segment seq of the stream name; every
+// segment is different.
+func data(name string, seq uint64, size int) []byte {
+	line := fmt.Sprintf("%s %d\n", name, seq)
+	data := bytes.Repeat([]byte(line), size/len(line)+1)
+	return data[:size]
+}
+
+// Data returns the data of segment seq of the stream name, as it is before
+// encryption.
+func (o *Origin) Data(name string, seq uint64) []byte {
+	o.mu.Lock()
+	s := o.streams[name]
+	o.mu.Unlock()
+	return data(name, seq, s.size())
+}
+
+// encrypt encrypts data with Key and seq as the IV, padding it with PKCS7.
+func encrypt(data []byte, seq uint64) []byte {
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	// § 5.2
+	// the Media Sequence Number is to be used as the IV when decrypting a
+	// Media Segment, by putting its big-endian binary representation into
+	// a 16-octet (128-bit) buffer and padding (on the left) with zeros.
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], seq)
+
+	block, _ := aes.NewCipher(Key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+// segment returns segment seq of s as it is served.
+func (s *Stream) segment(seq uint64) []byte {
+	data := data(s.name, seq, s.size())
+	if s.Encrypted {
+		data = encrypt(data, seq)
+	}
+	return data
+}
+
+// servedSize is the size of each segment as it is served.
+func (s *Stream) servedSize() int {
+	if s.Encrypted {
+		return (s.size()/aes.BlockSize + 1) * aes.BlockSize
+	}
+	return s.size()
+}
+
+// published returns the first and the last segment of s that are listed at
+// now, whether s has ended, and how many parts of the segment after the
+// last are published.
+func (s *Stream) published(now time.Time) (first, last uint64, ended bool, parts int) {
+	if !s.Live {
+		return 0, uint64(max(s.Segments, 1)) - 1, true, 0
+	}
+
+	elapsed := now.Sub(s.started)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	latest := s.window() - 1 + int(elapsed/s.duration())
+	if s.Segments > 0 && latest >= s.Segments-1 {
+		latest, ended = s.Segments-1, true
+	}
+	if !ended && s.Parts > 0 {
+		parts = int(elapsed % s.duration() / (s.duration() / time.Duration(s.Parts)))
+	}
+	if latest >= s.window() {
+		first = uint64(latest - s.window() + 1)
+	}
+	return first, uint64(latest), ended, parts
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func (s *Stream) discontinuity(seq uint64) bool {
+	for _, d := range s.Discontinuities {
+		if d == seq {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Stream) playlist(now time.Time) []byte {
+	first, last, ended, parts := s.published(now)
+	partDuration := s.duration() / time.Duration(max(s.Parts, 1))
+
+	version := 3
+	if s.Byterange {
+		version = 4
+	}
+	if s.Map {
+		version = 6
+	}
+	if s.Parts > 0 {
+		version = 9
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "#EXTM3U")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(s.duration().Seconds())))
+	if s.Parts > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%s\n", seconds(3*partDuration))
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", seconds(partDuration))
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+
+	var discontinuitySeq int
+	for _, d := range s.Discontinuities {
+		if d < first {
+			discontinuitySeq++
+		}
+	}
+	if discontinuitySeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySeq)
+	}
+	if !s.Live {
+		fmt.Fprintln(&b, "#EXT-X-PLAYLIST-TYPE:VOD")
+	}
+	if s.Encrypted {
+		fmt.Fprintln(&b, `#EXT-X-KEY:METHOD=AES-128,URI="key"`)
+	}
+	if s.Map {
+		fmt.Fprintln(&b, `#EXT-X-MAP:URI="init.mp4"`)
+	}
+
+	writeParts := func(seq uint64, n int) {
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=\"%d.%d.ts\"", seconds(partDuration), seq, i)
+			if i == 0 {
+				b.WriteString(",INDEPENDENT=YES")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	for seq := first; seq <= last; seq++ {
+		if s.discontinuity(seq) {
+			fmt.Fprintln(&b, "#EXT-X-DISCONTINUITY")
+		}
+		if !s.ProgramDateTime.IsZero() {
+			pdt := s.ProgramDateTime.Add(time.Duration(seq) * s.duration())
+			fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", pdt.UTC().Format(time.RFC3339Nano))
+		}
+		writeParts(seq, s.Parts)
+		fmt.Fprintf(&b, "#EXTINF:%s,\n", seconds(s.duration()))
+		if s.Byterange {
+			fmt.Fprintf(&b, "#EXT-X-BYTERANGE:%d@%d\n", s.servedSize(), int(seq)*s.servedSize())
+			fmt.Fprintln(&b, "all.ts")
+		} else {
+			fmt.Fprintf(&b, "%d.ts\n", seq)
+		}
+	}
+	writeParts(last+1, parts)
+
+	if ended {
+		fmt.Fprintln(&b, "#EXT-X-ENDLIST")
+	}
+	return b.Bytes()
+}
+
+func (o *Origin) master() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "#EXTM3U")
+	for _, name := range o.names {
+		s := o.streams[name]
+		bandwidth := s.Bandwidth
+		if bandwidth == 0 {
+			bandwidth = int(float64(s.servedSize()*8) / s.duration().Seconds())
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+		if s.Resolution != "" {
+			fmt.Fprintf(&b, ",RESOLUTION=%s", s.Resolution)
+		}
+		fmt.Fprintf(&b, "\n%s/index.m3u8\n", name)
+	}
+	return b.Bytes()
+}
+
+// parseRange parses a Range header of a single range, like bytes=0-99 or
+// bytes=100-, of a resource of size bytes.
+func parseRange(header string, size int) (start, end int, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	i := strings.IndexByte(spec, '-')
+	if spec == header || i < 0 {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(spec[:i])
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if spec[i+1:] != "" {
+		if end, err = strconv.Atoi(spec[i+1:]); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+// resource returns what is at /name/file, or nil if nothing is.
+func (o *Origin) resource(name, file string) []byte {
+	o.mu.Lock()
+	s := o.streams[name]
+	o.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+
+	_, last, ended, parts := s.published(o.now())
+	switch file {
+	case "index.m3u8":
+		return s.playlist(o.now())
+	case "key":
+		if s.Encrypted {
+			return Key
+		}
+		return nil
+	case "init.mp4":
+		if s.Map {
+			return []byte("init " + name)
+		}
+		return nil
+	case "all.ts":
+		if !s.Byterange {
+			return nil
+		}
+		var data []byte
+		for seq := uint64(0); seq <= last; seq++ {
+			data = append(data, s.segment(seq)...)
+		}
+		return data
+	}
+
+	var seq uint64
+	var part int
+	if n, _ := fmt.Sscanf(file, "%d.%d.ts", &seq, &part); n == 2 && s.Parts > 0 && part < s.Parts {
+		if seq > last+1 || (seq == last+1 && (ended || part >= parts)) {
+			return nil
+		}
+		data := data(name, seq, s.size())
+		partSize := (len(data) + s.Parts - 1) / s.Parts
+		return data[min(part*partSize, len(data)):min((part+1)*partSize, len(data))]
+	}
+	if n, _ := fmt.Sscanf(file, "%d.ts", &seq); n == 1 && !s.Byterange && file == fmt.Sprintf("%d.ts", seq) && seq <= last {
+		return s.segment(seq)
+	}
+	return nil
+}
+
+func (o *Origin) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.requests[r.URL.Path]++
+	o.mu.Unlock()
+
+	f := o.takeFault(r.URL.Path)
+	if f.respond(w) {
+		return
+	}
+
+	var body []byte
+	if r.URL.Path == "/master.m3u8" {
+		body = o.master()
+	} else if name, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/"); ok {
+		body = o.resource(name, file)
+	}
+	if body == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".m3u8"):
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	case strings.HasSuffix(r.URL.Path, ".ts"):
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+
+	status := http.StatusOK
+	if start, end, ok := parseRange(r.Header.Get("Range"), len(body)); ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		body, status = body[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	f.write(w, body)
+}
@@ -0,0 +1,172 @@
+package hlstest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, o *Origin, path string, header ...string) (*http.Response, string, error) {
+	req, _ := http.NewRequest("GET", o.URL+path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	r, err := o.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	return r, string(body), err
+}
+
+// segments lists the URIs of the segments and parts of a playlist.
+func segments(playlist string) []string {
+	var uris []string
+	for _, line := range strings.Split(playlist, "\n") {
+		if i := strings.Index(line, `URI="`); strings.HasPrefix(line, "#EXT-X-PART:") && i >= 0 {
+			uris = append(uris, strings.Split(line[i+len(`URI="`):], `"`)[0])
+		} else if line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	return uris
+}
+
+func TestLive(t *testing.T) {
+	clock := NewClock(time.Date(2020, 3, 4, 19, 0, 0, 0, time.UTC))
+	o := NewOrigin(clock)
+	defer o.Close()
+	o.AddStream("live", Stream{Live: true, Segments: 5, Window: 2, Discontinuities: []uint64{1}})
+
+	var tests = []struct {
+		advance  time.Duration
+		expected []string
+		header   string
+	}{
+		{0, []string{"0.ts", "1.ts"}, "#EXT-X-MEDIA-SEQUENCE:0\n"},
+		{3 * time.Second, []string{"0.ts", "1.ts"}, "#EXT-X-MEDIA-SEQUENCE:0\n"},
+		{time.Second, []string{"1.ts", "2.ts"}, "#EXT-X-MEDIA-SEQUENCE:1\n"},
+		{4 * time.Second, []string{"2.ts", "3.ts"}, "#EXT-X-DISCONTINUITY-SEQUENCE:1\n"},
+		{time.Hour, []string{"3.ts", "4.ts"}, "#EXT-X-ENDLIST\n"},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		_, playlist, _ := get(t, o, "/live/index.m3u8")
+		if found := segments(playlist); strings.Join(found, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("at %s: expected %v, found %v", clock.Now().Format(time.TimeOnly), tt.expected, found)
+		}
+		if !strings.Contains(playlist, tt.header) {
+			t.Errorf("at %s: expected %q in\n%s", clock.Now().Format(time.TimeOnly), tt.header, playlist)
+		}
+	}
+
+	if r, body, _ := get(t, o, "/live/4.ts"); r.StatusCode != 200 || body != string(o.Data("live", 4)) {
+		t.Errorf("expected segment 4, found %s", r.Status)
+	}
+	if r, _, _ := get(t, o, "/live/5.ts"); r.StatusCode != 404 {
+		t.Errorf("expected a segment past the end to be missing, found %s", r.Status)
+	}
+}
+
+func TestParts(t *testing.T) {
+	clock := NewClock(time.Now())
+	o := NewOrigin(clock)
+	defer o.Close()
+	o.AddStream("ll", Stream{Live: true, Window: 1, Parts: 4})
+
+	clock.Advance(2 * time.Second)
+	_, playlist, _ := get(t, o, "/ll/index.m3u8")
+	expected := []string{"0.0.ts", "0.1.ts", "0.2.ts", "0.3.ts", "0.ts", "1.0.ts", "1.1.ts"}
+	if found := segments(playlist); strings.Join(found, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, found %v", expected, found)
+	}
+	if !strings.Contains(playlist, "#EXT-X-PART-INF:PART-TARGET=1\n") {
+		t.Errorf("expected parts of 1 second in\n%s", playlist)
+	}
+
+	if r, body, _ := get(t, o, "/ll/1.1.ts"); r.StatusCode != 200 || body != string(o.Data("ll", 1)[250:500]) {
+		t.Errorf("expected the second quarter of segment 1, found %s", r.Status)
+	}
+	if r, _, _ := get(t, o, "/ll/1.2.ts"); r.StatusCode != 404 {
+		t.Errorf("expected an unpublished part to be missing, found %s", r.Status)
+	}
+}
+
+func TestEncryptedByterange(t *testing.T) {
+	o := NewOrigin(nil)
+	defer o.Close()
+	o.AddStream("vod", Stream{Segments: 3, Size: 100, Encrypted: true, Byterange: true})
+
+	_, playlist, _ := get(t, o, "/vod/index.m3u8")
+	for _, tag := range []string{"#EXT-X-PLAYLIST-TYPE:VOD\n", `#EXT-X-KEY:METHOD=AES-128,URI="key"`, "#EXT-X-BYTERANGE:112@112\n", "#EXT-X-ENDLIST\n"} {
+		if !strings.Contains(playlist, tag) {
+			t.Errorf("expected %s in\n%s", tag, playlist)
+		}
+	}
+
+	r, segment, _ := get(t, o, "/vod/all.ts", "Range", "bytes=112-223")
+	if r.StatusCode != 206 || len(segment) != 112 {
+		t.Fatalf("expected 112 bytes of partial content, found %s and %d bytes", r.Status, len(segment))
+	}
+	_, key, _ := get(t, o, "/vod/key")
+
+	block, _ := aes.NewCipher([]byte(key))
+	iv := make([]byte, aes.BlockSize)
+	iv[aes.BlockSize-1] = 1
+	decrypted := []byte(segment)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, decrypted)
+	if expected := o.Data("vod", 1); !bytes.Equal(decrypted[:100], expected) {
+		t.Errorf("expected %q, found %q", expected, decrypted[:100])
+	}
+}
+
+func TestFaults(t *testing.T) {
+	o := NewOrigin(nil)
+	defer o.Close()
+	o.AddStream("vod", Stream{Segments: 2})
+
+	o.Fail("/vod/*.ts", Fault{Status: 503, RetryAfter: 1500 * time.Millisecond, Times: 1})
+	o.Fail("/vod/1.ts", Fault{Truncate: 10})
+
+	if r, _, _ := get(t, o, "/vod/0.ts"); r.StatusCode != 503 || r.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected 503 with Retry-After: 2, found %s and %q", r.Status, r.Header.Get("Retry-After"))
+	}
+	if r, _, err := get(t, o, "/vod/0.ts"); r.StatusCode != 200 || err != nil {
+		t.Errorf("expected the fault to be gone, found %s (%v)", r.Status, err)
+	}
+	if _, body, err := get(t, o, "/vod/1.ts"); err != io.ErrUnexpectedEOF || len(body) != 10 {
+		t.Errorf("expected a truncated body, found %d bytes (%v)", len(body), err)
+	}
+	if n := o.Requests("/vod/0.ts"); n != 2 {
+		t.Errorf("expected 2 requests, found %d", n)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Now()
+	clock := NewClock(start)
+	c := clock.After(time.Second)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatal("expected the timer not to fire early")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	if now := <-c; !now.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the timer to fire at %v, found %v", start.Add(time.Second), now)
+	}
+
+	auto := NewAutoClock(start)
+	<-auto.After(time.Hour)
+	if waited := auto.Now().Sub(start); waited != time.Hour {
+		t.Errorf("expected the clock to jump an hour, found %s", waited)
+	}
+}
@@ -2,6 +2,7 @@ package hls
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/url"
 	"strconv"
@@ -13,18 +14,45 @@ import (
 
 type CommonPlaylistTags struct {
 	IndependentSegments bool
-	StartOffset         time.Duration
-	StartPrecise        bool
+
+	// HasStart is set if EXT-X-START is present, with StartOffset and
+	// StartPrecise as its attributes; an offset of zero is meaningful.
+	HasStart     bool
+	StartOffset  time.Duration
+	StartPrecise bool
+}
+
+// SessionData is an EXT-X-SESSION-DATA tag.  Exactly one of Value and URI is
+// set; the resource pointed to by URI is a JSON document.
+type SessionData struct {
+	DataID   string
+	Value    string
+	URI      string
+	Language string
 }
 
 type MasterPlaylist struct {
 	CommonPlaylistTags
+	SessionData []*SessionData
+	SessionKeys []*m3u8.Key
+
+	// Renditions are the EXT-X-MEDIA tags, in order, including those of the
+	// groups that no variant refers to.
+	Renditions []*m3u8.Alternative
+
 	*m3u8.MasterPlaylist
 }
 
+type MediaSegment struct {
+	Gap     bool  // EXT-X-GAP
+	Bitrate int64 // EXT-X-BITRATE, in kilobits per second
+	*m3u8.MediaSegment
+}
+
 type MediaPlaylist struct {
 	TargetDuration time.Duration
 	CommonPlaylistTags
+	Segments []*MediaSegment
 	*m3u8.MediaPlaylist
 }
 
@@ -45,25 +73,66 @@ func splitKV(line string) []string {
 	})
 }
 
+func unquote(value string) string {
+	return strings.Trim(value, `"`)
+}
+
+// decodeM3U8 is m3u8.Decode, except that it returns ErrMalformedPlaylist where
+// m3u8 panics, as it does for an EXT-X-KEY followed by a URI without EXTINF.
+func decodeM3U8(buf bytes.Buffer) (playlist m3u8.Playlist, playlistType m3u8.ListType, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			playlist, playlistType = nil, 0
+			err = fmt.Errorf("%w: %v", ErrMalformedPlaylist, r)
+		}
+	}()
+	return m3u8.Decode(buf, true)
+}
+
 func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlistType m3u8.ListType, err error) {
 	var playlistURL *url.URL
 	if playlistURL, err = url.Parse(playlistURI); err != nil {
 		return
 	}
 
-	var buf bytes.Buffer
-	if _, err = io.Copy(&buf, r); err != nil {
+	// § 4.1
+	// Blank lines are ignored.
+	//
+	// m3u8 takes them for URIs.
+	var body, buf bytes.Buffer
+	if _, err = io.Copy(&body, r); err != nil {
 		return
 	}
+	for _, line := range bytes.Split(body.Bytes(), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
 
-	playlist, playlistType, err = m3u8.Decode(buf, true)
+	playlist, playlistType, err = decodeM3U8(buf)
 	if err != nil {
 		return
 	}
 
 	var commonTags CommonPlaylistTags
-	line, bufErr := buf.ReadString('\n')
-	for ; bufErr == nil; line, bufErr = buf.ReadString('\n') {
+	var targetDuration float64
+	var sessionData []*SessionData
+	var sessionKeys []*m3u8.Key
+
+	// m3u8 gives each variant the EXT-X-MEDIA tags that precede it, and
+	// drops those that follow the last one.
+	var trailingRenditions []*m3u8.Alternative
+
+	// Tags that apply to Media Segments are collected in the order the
+	// segments appear, to be matched with those decoded by m3u8.
+	var segmentGaps, segmentOffsets []bool
+	var segmentBitrates []int64
+	var inf, gap, offset bool
+	var bitrate int64
+
+	for {
+		line, bufErr := buf.ReadString('\n')
 		line = strings.TrimSpace(line)
 
 		switch {
@@ -71,6 +140,7 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 			commonTags.IndependentSegments = true
 
 		case strings.HasPrefix(line, "#EXT-X-START:"):
+			commonTags.HasStart = true
 			for _, kv := range splitKV(line[13:]) {
 				switch {
 				case strings.HasPrefix(kv, "TIME-OFFSET="):
@@ -80,6 +150,100 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 					commonTags.StartPrecise = kv[8:] == "YES"
 				}
 			}
+
+		case strings.HasPrefix(line, "#EXT-X-SESSION-DATA:"):
+			data := new(SessionData)
+			for _, kv := range splitKV(line[20:]) {
+				switch {
+				case strings.HasPrefix(kv, "DATA-ID="):
+					data.DataID = unquote(kv[8:])
+				case strings.HasPrefix(kv, "VALUE="):
+					data.Value = unquote(kv[6:])
+				case strings.HasPrefix(kv, "URI="):
+					data.URI = unquote(kv[4:])
+				case strings.HasPrefix(kv, "LANGUAGE="):
+					data.Language = unquote(kv[9:])
+				}
+			}
+			sessionData = append(sessionData, data)
+
+		case strings.HasPrefix(line, "#EXT-X-SESSION-KEY:"):
+			key := new(m3u8.Key)
+			for _, kv := range splitKV(line[19:]) {
+				switch {
+				case strings.HasPrefix(kv, "METHOD="):
+					key.Method = kv[7:]
+				case strings.HasPrefix(kv, "URI="):
+					key.URI = unquote(kv[4:])
+				case strings.HasPrefix(kv, "IV="):
+					key.IV = kv[3:]
+				case strings.HasPrefix(kv, "KEYFORMAT="):
+					key.Keyformat = unquote(kv[10:])
+				case strings.HasPrefix(kv, "KEYFORMATVERSIONS="):
+					key.Keyformatversions = unquote(kv[18:])
+				}
+			}
+			sessionKeys = append(sessionKeys, key)
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			alt := new(m3u8.Alternative)
+			for _, kv := range splitKV(line[13:]) {
+				switch {
+				case strings.HasPrefix(kv, "TYPE="):
+					alt.Type = unquote(kv[5:])
+				case strings.HasPrefix(kv, "GROUP-ID="):
+					alt.GroupId = unquote(kv[9:])
+				case strings.HasPrefix(kv, "LANGUAGE="):
+					alt.Language = unquote(kv[9:])
+				case strings.HasPrefix(kv, "NAME="):
+					alt.Name = unquote(kv[5:])
+				case strings.HasPrefix(kv, "DEFAULT="):
+					alt.Default = strings.ToUpper(unquote(kv[8:])) == "YES"
+				case strings.HasPrefix(kv, "AUTOSELECT="):
+					alt.Autoselect = unquote(kv[11:])
+				case strings.HasPrefix(kv, "FORCED="):
+					alt.Forced = unquote(kv[7:])
+				case strings.HasPrefix(kv, "CHARACTERISTICS="):
+					alt.Characteristics = unquote(kv[16:])
+				case strings.HasPrefix(kv, "SUBTITLES="):
+					alt.Subtitles = unquote(kv[10:])
+				case strings.HasPrefix(kv, "URI="):
+					alt.URI = unquote(kv[4:])
+				}
+			}
+			trailingRenditions = append(trailingRenditions, alt)
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"), strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+			trailingRenditions = nil
+
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			// A value that m3u8 accepted but that is not a plain number,
+			// like one followed by junk, leaves the reading of m3u8.
+			targetDuration, _ = strconv.ParseFloat(line[22:], 64)
+
+		case strings.HasPrefix(line, "#EXTINF:"):
+			inf = true
+
+		case strings.HasPrefix(line, "#EXT-X-GAP"):
+			gap = true
+
+		case strings.HasPrefix(line, "#EXT-X-BITRATE:"):
+			bitrate, _ = strconv.ParseInt(line[15:], 10, 64)
+
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			offset = strings.Contains(line, "@")
+
+		case line != "" && !strings.HasPrefix(line, "#"):
+			if inf {
+				segmentGaps = append(segmentGaps, gap)
+				segmentOffsets = append(segmentOffsets, offset)
+				segmentBitrates = append(segmentBitrates, bitrate)
+			}
+			inf, gap, offset = false, false, false
+		}
+
+		if bufErr != nil {
+			break
 		}
 	}
 
@@ -91,23 +255,47 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 		// A set of one or more EXT-X-MEDIA tags with the same GROUP-ID value
 		// and the same TYPE value defines a Group of Renditions.
 		renditionGroups := make(map[renditionGroupKey][]*m3u8.Alternative)
+		var renditions []*m3u8.Alternative
 		for _, variant := range master.Variants {
 			var variantURL *url.URL
 			if variantURL, err = playlistURL.Parse(variant.URI); err != nil {
 				return
 			}
 			variant.URI = variantURL.String()
+			renditions = append(renditions, variant.Alternatives...)
+		}
+		renditions = append(renditions, trailingRenditions...)
 
-			for _, alt := range variant.Alternatives {
+		for _, alt := range renditions {
+			if alt.URI != "" {
 				var altURL *url.URL
 				if altURL, err = playlistURL.Parse(alt.URI); err != nil {
 					return
 				}
 				alt.URI = altURL.String()
+			}
 
-				g := renditionGroupKey{alt.Type, alt.GroupId}
-				renditionGroups[g] = append(renditionGroups[g], alt)
+			g := renditionGroupKey{alt.Type, alt.GroupId}
+			renditionGroups[g] = append(renditionGroups[g], alt)
+		}
+
+		for _, data := range sessionData {
+			if data.URI == "" {
+				continue
 			}
+			var dataURL *url.URL
+			if dataURL, err = playlistURL.Parse(data.URI); err != nil {
+				return
+			}
+			data.URI = dataURL.String()
+		}
+
+		for _, key := range sessionKeys {
+			var keyURL *url.URL
+			if keyURL, err = playlistURL.Parse(key.URI); err != nil {
+				return
+			}
+			key.URI = keyURL.String()
 		}
 
 		for _, v := range master.Variants {
@@ -115,6 +303,7 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 				{"VIDEO", v.Video},
 				{"AUDIO", v.Audio},
 				{"SUBTITLES", v.Subtitles},
+				{"CLOSED-CAPTIONS", v.Captions},
 			}
 
 			v.Alternatives = nil
@@ -128,14 +317,43 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 		playlist = &MasterPlaylist{
 			MasterPlaylist:     master,
 			CommonPlaylistTags: commonTags,
+			SessionData:        sessionData,
+			SessionKeys:        sessionKeys,
+			Renditions:         renditions,
 		}
 
 	case m3u8.MEDIA:
 		media := playlist.(*m3u8.MediaPlaylist)
 
+		// § 4.3.3.1
+		// The EXTINF duration of each Media Segment in the Playlist file,
+		// when rounded to the nearest integer, MUST be less than or equal to
+		// the target duration.
+		//
+		// m3u8 raises it to the ceiling of longer segments instead.
+		if targetDuration > 0 {
+			media.TargetDuration = targetDuration
+		}
+
 		var key *m3u8.Key
+		var xmap *m3u8.Map
 		media.Segments = media.Segments[:media.Count()]
+		segments := make([]*MediaSegment, len(media.Segments))
 		for i, seg := range media.Segments {
+			segments[i] = &MediaSegment{MediaSegment: seg}
+			if i < len(segmentGaps) {
+				segments[i].Gap = segmentGaps[i]
+
+				// § 4.3.2.8
+				// The EXT-X-BITRATE tag applies to every Media Segment between
+				// it and the next EXT-X-BITRATE tag in the Playlist file (or
+				// the end of the Playlist file).  Media Segments that have an
+				// EXT-X-BYTERANGE tag applied to them are excluded.
+				if seg.Limit == 0 {
+					segments[i].Bitrate = segmentBitrates[i]
+				}
+			}
+
 			seg.SeqId = media.SeqNo + uint64(i)
 
 			var segURL *url.URL
@@ -144,13 +362,28 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 			}
 			seg.URI = segURL.String()
 
+			// § 4.3.2.2
+			// If o is not present, the sub-range begins at the next byte
+			// following the sub-range of the previous Media Segment.
+			if i > 0 && i < len(segmentOffsets) && seg.Limit > 0 && !segmentOffsets[i] {
+				if prev := media.Segments[i-1]; prev.URI == seg.URI {
+					seg.Offset = prev.Offset + prev.Limit
+				}
+			}
+
+			// § 4.3.2.5
+			// It applies to every Media Segment that appears after it in the
+			// Playlist until the next EXT-X-MAP tag or until the end of the
+			// Playlist.
 			if seg.Map != nil {
+				xmap = seg.Map
 				var mapURL *url.URL
-				if mapURL, err = playlistURL.Parse(seg.Map.URI); err != nil {
+				if mapURL, err = playlistURL.Parse(xmap.URI); err != nil {
 					return
 				}
-				seg.Map.URI = mapURL.String()
+				xmap.URI = mapURL.String()
 			}
+			seg.Map = xmap
 
 			if seg.Key != nil {
 				if strings.ToUpper(seg.Key.Method) == "NONE" {
@@ -169,6 +402,7 @@ func parseM3U8(r io.Reader, playlistURI string) (playlist m3u8.Playlist, playlis
 
 		playlist = &MediaPlaylist{
 			TargetDuration:     time.Duration(media.TargetDuration * 1e9),
+			Segments:           segments,
 			MediaPlaylist:      media,
 			CommonPlaylistTags: commonTags,
 		}
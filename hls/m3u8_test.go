@@ -1,6 +1,8 @@
 package hls
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -8,6 +10,89 @@ import (
 	"github.com/grafov/m3u8"
 )
 
+const (
+	relativeMasterPlaylist = `
+		#EXTM3U
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="audio.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="audio"
+		video.m3u8
+	`
+
+	relativeMediaPlaylist = `
+		#EXTM3U
+		#EXT-X-VERSION:6
+		#EXT-X-TARGETDURATION:10
+		#EXT-X-KEY:METHOD=AES-128,URI="key"
+		#EXT-X-MAP:URI="map"
+		#EXTINF:9.0,
+		seg.ts
+	`
+
+	absoluteMasterPlaylist = `
+		#EXTM3U
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="http://example.org/audio.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="audio"
+		http://example.org/video.m3u8
+	`
+
+	absoluteMediaPlaylist = `
+		#EXTM3U
+		#EXT-X-VERSION:6
+		#EXT-X-TARGETDURATION:10
+		#EXT-X-KEY:METHOD=AES-128,URI="http://example.org/key"
+		#EXT-X-MAP:URI="http://example.org/map"
+		#EXTINF:9.0,
+		http://example.org/seg.ts
+	`
+
+	extraTagsPlaylist = `
+		#EXTM3U
+		#EXT-X-INDEPENDENT-SEGMENTS
+		#EXT-X-START:TIME-OFFSET=1.2,PRECISE=YES
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000
+		media.m3u8
+	`
+
+	renditionsPlaylist = `
+		#EXTM3U
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",URI="english-audio.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=65000,AUDIO="aac"
+		english-audio.m3u8
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="german-audio.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+		video-only.m3u8
+		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Commentary",LANGUAGE="en",URI="commentary-audio.m3u8"
+		#EXT-X-STREAM-INF:BANDWIDTH=2560000,AUDIO="aac"
+		mid/video-only.m3u8
+	`
+
+	sessionTagsPlaylist = `
+		#EXTM3U
+		#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="This is an example",LANGUAGE="en"
+		#EXT-X-SESSION-DATA:DATA-ID="com.example.lyrics",URI="lyrics.json"
+		#EXT-X-SESSION-KEY:METHOD=AES-128,URI="keys/session.key",IV=0x9c7db8778570d05c3177c349fd9236aa
+		#EXT-X-STREAM-INF:BANDWIDTH=1280000
+		media.m3u8
+	`
+
+	gapAndBitratePlaylist = `
+		#EXTM3U
+		#EXT-X-VERSION:8
+		#EXT-X-TARGETDURATION:10
+		#EXT-X-BITRATE:1500
+		#EXTINF:9.0,
+		seg1.ts
+		#EXT-X-GAP
+		#EXTINF:9.0,
+		seg2.ts
+		#EXT-X-BYTERANGE:1000@0
+		#EXTINF:9.0,
+		seg3.ts
+		#EXT-X-BITRATE:800
+		#EXTINF:9.0,
+		seg4.ts`
+)
+
 func compareUnsortedStrings(expected, actual []string) (more, less []string) {
 	for _, e := range expected {
 		var found bool
@@ -38,12 +123,7 @@ func compareUnsortedStrings(expected, actual []string) (more, less []string) {
 }
 
 func TestRelativeURIs(t *testing.T) {
-	playlist, playlistType, err := parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="audio.m3u8"
-		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="audio"
-		video.m3u8
-	`), "http://example.org/master.m3u8")
+	playlist, playlistType, err := parseM3U8(strings.NewReader(relativeMasterPlaylist), "http://example.org/master.m3u8")
 
 	if err != nil {
 		t.Fatal(err)
@@ -68,15 +148,7 @@ func TestRelativeURIs(t *testing.T) {
 		}
 	}
 
-	playlist, playlistType, err = parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-VERSION:6
-		#EXT-X-TARGETDURATION:10
-		#EXT-X-KEY:METHOD=AES-128,URI="key"
-		#EXT-X-MAP:URI="map"
-		#EXTINF:9.0,
-		seg.ts
-	`), "http://example.org/media.m3u8")
+	playlist, playlistType, err = parseM3U8(strings.NewReader(relativeMediaPlaylist), "http://example.org/media.m3u8")
 
 	if err != nil {
 		t.Fatal(err)
@@ -105,12 +177,7 @@ func TestRelativeURIs(t *testing.T) {
 }
 
 func TestAbsoluteURIs(t *testing.T) {
-	playlist, playlistType, err := parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",URI="http://example.org/audio.m3u8"
-		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="audio"
-		http://example.org/video.m3u8
-	`), "http://example.org/master.m3u8")
+	playlist, playlistType, err := parseM3U8(strings.NewReader(absoluteMasterPlaylist), "http://example.org/master.m3u8")
 
 	if err != nil {
 		t.Fatal(err)
@@ -135,15 +202,7 @@ func TestAbsoluteURIs(t *testing.T) {
 		}
 	}
 
-	playlist, playlistType, err = parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-VERSION:6
-		#EXT-X-TARGETDURATION:10
-		#EXT-X-KEY:METHOD=AES-128,URI="http://example.org/key"
-		#EXT-X-MAP:URI="http://example.org/map"
-		#EXTINF:9.0,
-		http://example.org/seg.ts
-	`), "http://example.org/media.m3u8")
+	playlist, playlistType, err = parseM3U8(strings.NewReader(absoluteMediaPlaylist), "http://example.org/media.m3u8")
 
 	if err != nil {
 		t.Fatal(err)
@@ -172,13 +231,7 @@ func TestAbsoluteURIs(t *testing.T) {
 }
 
 func TestParsingExtraTags(t *testing.T) {
-	playlist, playlistType, err := parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-INDEPENDENT-SEGMENTS
-		#EXT-X-START:TIME-OFFSET=1.2,PRECISE=YES
-		#EXT-X-STREAM-INF:BANDWIDTH=1280000
-		media.m3u8
-	`), "")
+	playlist, playlistType, err := parseM3U8(strings.NewReader(extraTagsPlaylist), "")
 
 	if err != nil {
 		t.Fatal(err)
@@ -201,18 +254,7 @@ func TestParsingExtraTags(t *testing.T) {
 }
 
 func TestRenditions(t *testing.T) {
-	playlist, playlistType, err := parseM3U8(strings.NewReader(`
-		#EXTM3U
-		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",URI="english-audio.m3u8"
-		#EXT-X-STREAM-INF:BANDWIDTH=65000,AUDIO="aac"
-		english-audio.m3u8
-		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="german-audio.m3u8"
-		#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
-		video-only.m3u8
-		#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Commentary",LANGUAGE="en",URI="commentary-audio.m3u8"
-		#EXT-X-STREAM-INF:BANDWIDTH=2560000,AUDIO="aac"
-		mid/video-only.m3u8
-	`), "")
+	playlist, playlistType, err := parseM3U8(strings.NewReader(renditionsPlaylist), "")
 
 	if err != nil {
 		t.Fatal(err)
@@ -241,3 +283,123 @@ func TestRenditions(t *testing.T) {
 		}
 	}
 }
+
+func TestTrailingRenditions(t *testing.T) {
+	playlist, _, err := parseM3U8(strings.NewReader(`#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac",SUBTITLES="subs"
+video.m3u8
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",URI="subs/en.m3u8"
+`), "http://example.com/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := playlist.(*MasterPlaylist)
+
+	// § 4.3.4.1
+	// The EXT-X-MEDIA tag is used to relate Media Playlists that contain
+	// alternative Renditions of the same content.
+	//
+	// It may come after the variants that refer to its group.
+	var renditions, alternatives []string
+	for _, r := range master.Renditions {
+		renditions = append(renditions, r.URI)
+	}
+	for _, r := range master.Variants[0].Alternatives {
+		alternatives = append(alternatives, r.URI)
+	}
+	expected := []string{
+		"http://example.com/en.m3u8",
+		"http://example.com/de.m3u8",
+		"http://example.com/subs/en.m3u8",
+	}
+	if !reflect.DeepEqual(renditions, expected) {
+		t.Errorf("expected renditions %v, found %v", expected, renditions)
+	}
+	if !reflect.DeepEqual(alternatives, expected) {
+		t.Errorf("expected the variant to have renditions %v, found %v", expected, alternatives)
+	}
+	if de := master.Renditions[1]; de.Type != "AUDIO" || de.GroupId != "aac" || de.Language != "de" || de.Name != "Deutsch" {
+		t.Errorf("unexpected rendition %+v", de)
+	}
+}
+
+func TestSessionTags(t *testing.T) {
+	playlist, playlistType, err := parseM3U8(strings.NewReader(sessionTagsPlaylist), "http://example.org/master.m3u8")
+
+	if err != nil {
+		t.Fatal(err)
+	} else if playlistType != m3u8.MASTER {
+		t.Fatal("should be Master Playlist")
+	}
+	master := playlist.(*MasterPlaylist)
+
+	if len(master.SessionData) != 2 {
+		t.Fatal("expected 2 EXT-X-SESSION-DATA tags, found", len(master.SessionData))
+	}
+
+	title := master.SessionData[0]
+	if title.DataID != "com.example.title" || title.Value != "This is an example" || title.Language != "en" {
+		t.Error("did not parse EXT-X-SESSION-DATA with VALUE", *title)
+	}
+
+	lyrics := master.SessionData[1]
+	if lyrics.DataID != "com.example.lyrics" || lyrics.URI != "http://example.org/lyrics.json" {
+		t.Error("did not parse EXT-X-SESSION-DATA with URI", *lyrics)
+	}
+
+	if len(master.SessionKeys) != 1 {
+		t.Fatal("expected 1 EXT-X-SESSION-KEY tag, found", len(master.SessionKeys))
+	}
+
+	key := master.SessionKeys[0]
+	if key.Method != "AES-128" || key.URI != "http://example.org/keys/session.key" || key.IV != "0x9c7db8778570d05c3177c349fd9236aa" {
+		t.Error("did not parse EXT-X-SESSION-KEY", *key)
+	}
+}
+
+func TestGapAndBitrate(t *testing.T) {
+	playlist, playlistType, err := parseM3U8(strings.NewReader(gapAndBitratePlaylist), "http://example.org/media.m3u8")
+
+	if err != nil {
+		t.Fatal(err)
+	} else if playlistType != m3u8.MEDIA {
+		t.Fatal("should be Media Playlist")
+	}
+	media := playlist.(*MediaPlaylist)
+
+	if len(media.Segments) != 4 {
+		t.Fatal("expected 4 segments, found", len(media.Segments))
+	}
+
+	expectedGaps := []bool{false, true, false, false}
+	expectedBitrates := []int64{1500, 1500, 0, 800}
+	for i, seg := range media.Segments {
+		if seg.Gap != expectedGaps[i] {
+			t.Error("segment", i, "EXT-X-GAP expected", expectedGaps[i], "found", seg.Gap)
+		}
+		if seg.Bitrate != expectedBitrates[i] {
+			t.Error("segment", i, "EXT-X-BITRATE expected", expectedBitrates[i], "found", seg.Bitrate)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	// m3u8 panics on a key with no segment to apply to.
+	_, _, err := parseM3U8(strings.NewReader("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\nseg.ts\n"), "http://example.org/media.m3u8")
+	if !errors.Is(err, ErrMalformedPlaylist) {
+		t.Error("expected ErrMalformedPlaylist, found", err)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	title := strings.Repeat("x", 2<<20)
+	playlist, _, err := parseM3U8(strings.NewReader("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,"+title+"\nseg.ts\n"), "http://example.org/media.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media := playlist.(*MediaPlaylist); len(media.Segments) != 1 || media.Segments[0].Title != title {
+		t.Error("did not parse a segment with a title of", len(title), "bytes")
+	}
+}
@@ -0,0 +1,258 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"golang.org/x/sync/errgroup"
+)
+
+// writeFile writes the file atomically, so that a server never sees it
+// half-written.
+func writeFile(filename string, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	// TempFile makes files that only their owner can read, which would
+	// keep the web server of the mirror out.
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func copyFile(filename string, r io.ReadCloser) error {
+	defer r.Close()
+	return writeFile(filename, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+func extension(uri, fallback string) string {
+	u, err := url.Parse(uri)
+	if err != nil || path.Ext(u.Path) == "" {
+		return fallback
+	}
+	return path.Ext(u.Path)
+}
+
+// Mirror saves the Master Playlist, the Media Playlists of variant and of its
+// renditions, and the segments, keys and maps they refer to under dir, with
+// their URIs rewritten so that dir can be served by a static file server.
+// Live playlists are followed until they end; in the meantime they are saved
+// as EVENT playlists that grow with every segment.
+func (h Client) Mirror(ctx context.Context, master *MasterPlaylist, variant *m3u8.Variant, dir string) error {
+	names := map[string]string{variant.URI: "video"}
+	for i, alt := range variant.Alternatives {
+		if _, ok := names[alt.URI]; !ok && alt.URI != "" {
+			names[alt.URI] = fmt.Sprintf("%s-%d", strings.ToLower(alt.Type), i)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// EXT-X-START is dropped, since the mirror already begins there.
+	local := &MasterPlaylist{
+		CommonPlaylistTags: CommonPlaylistTags{IndependentSegments: master.IndependentSegments},
+		MasterPlaylist:     m3u8.NewMasterPlaylist(),
+	}
+	local.Variants = []*m3u8.Variant{variant}
+
+	for i, data := range master.SessionData {
+		if data.URI == "" {
+			local.SessionData = append(local.SessionData, data)
+			continue
+		}
+
+		raw, err := h.ReadSessionData(ctx, data)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("session-%d.json", i)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), raw, 0644); err != nil {
+			return err
+		}
+		names[data.URI] = name
+
+		local.SessionData = append(local.SessionData, data)
+	}
+
+	err := writeFile(filepath.Join(dir, "index.m3u8"), func(w io.Writer) error {
+		return local.EncodeTo(w, func(uri string) string {
+			if name, ok := names[uri]; ok && path.Ext(name) == "" {
+				return name + "/index.m3u8"
+			} else if ok {
+				return name
+			}
+			return uri
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for uri, name := range names {
+		if path.Ext(name) != "" {
+			continue
+		}
+
+		uri, name := uri, name
+		g.Go(func() error {
+			return h.mirrorMedia(ctx, uri, filepath.Join(dir, name))
+		})
+	}
+	return g.Wait()
+}
+
+func (h Client) mirrorMedia(ctx context.Context, uri, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var local *MediaPlaylist
+	keys := make(map[m3u8.Key]*m3u8.Key)
+	maps := make(map[m3u8.Map]*m3u8.Map)
+	report := new(Report)
+
+	// localKey saves the key, unless it cannot be fetched over HTTP (like
+	// the skd:// URIs of FairPlay), in which case it is left as it is.
+	localKey := func(ctx context.Context, key *m3u8.Key, timeout time.Duration) (*m3u8.Key, error) {
+		if key == nil {
+			return nil, nil
+		} else if k, ok := keys[*key]; ok {
+			return k, nil
+		}
+
+		k := *key
+		if u, err := url.Parse(key.URI); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			r, err := h.fetch(ctx, key.URI, 0, 0, timeout)
+			if err != nil {
+				return nil, err
+			}
+			k.URI = fmt.Sprintf("key-%d.key", len(keys))
+			if err := copyFile(filepath.Join(dir, k.URI), r); err != nil {
+				return nil, err
+			}
+		}
+
+		keys[*key] = &k
+		return &k, nil
+	}
+
+	localMap := func(ctx context.Context, xmap *m3u8.Map, timeout time.Duration) (*m3u8.Map, error) {
+		if xmap == nil {
+			return nil, nil
+		} else if m, ok := maps[*xmap]; ok {
+			return m, nil
+		}
+
+		r, err := h.fetch(ctx, xmap.URI, xmap.Limit, xmap.Offset, timeout)
+		if err != nil {
+			return nil, err
+		}
+		m := &m3u8.Map{URI: fmt.Sprintf("init-%d%s", len(maps), extension(xmap.URI, ".mp4"))}
+		if err := copyFile(filepath.Join(dir, m.URI), r); err != nil {
+			return nil, err
+		}
+
+		maps[*xmap] = m
+		return m, nil
+	}
+
+	savePlaylist := func() error {
+		return writeFile(filepath.Join(dir, "index.m3u8"), func(w io.Writer) error {
+			return local.EncodeTo(w, nil)
+		})
+	}
+
+	err := h.follow(ctx, uri, report, func(media *MediaPlaylist, seg *MediaSegment) error {
+		timeout := 2 * media.TargetDuration
+
+		key, err := localKey(ctx, seg.Key, timeout)
+		if err != nil {
+			return err
+		}
+		xmap, err := localMap(ctx, seg.Map, timeout)
+		if err != nil {
+			return err
+		}
+
+		h.logger().Debug("downloading segment", "uri", seg.URI, "seq", seg.SeqId)
+		r, err := h.fetch(ctx, seg.URI, seg.Limit, seg.Offset, timeout)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%d%s", seg.SeqId, extension(seg.URI, ".ts"))
+		if err := copyFile(filepath.Join(dir, name), r); err != nil {
+			return err
+		}
+		report.Segments++
+
+		if local == nil {
+			header := *media.MediaPlaylist
+			local = &MediaPlaylist{
+				TargetDuration:     media.TargetDuration,
+				CommonPlaylistTags: CommonPlaylistTags{IndependentSegments: media.IndependentSegments},
+				MediaPlaylist:      &header,
+			}
+			local.SeqNo = seg.SeqId
+			local.MediaType = m3u8.EVENT
+			local.Closed = false
+
+			// The first segment keeps its own EXT-X-DISCONTINUITY; those of
+			// the segments before it are counted instead.
+			for _, s := range media.Segments {
+				if s.SeqId >= media.SeqNo && s.SeqId < seg.SeqId && s.Discontinuity {
+					local.DiscontinuitySeq++
+				}
+			}
+		}
+
+		saved := *seg.MediaSegment
+		saved.URI = name
+		saved.Limit, saved.Offset = 0, 0
+		saved.Key, saved.Map = key, xmap
+
+		// Segments that expired or could not be filled leave a hole that
+		// players should not try to play through.
+		if n := len(local.Segments); n > 0 && local.Segments[n-1].SeqId+1 != seg.SeqId {
+			saved.Discontinuity = true
+		}
+
+		local.Segments = append(local.Segments, &MediaSegment{
+			Bitrate:      seg.Bitrate,
+			MediaSegment: &saved,
+		})
+		return savePlaylist()
+	})
+	if err != nil || local == nil {
+		return err
+	}
+
+	local.Closed = true
+	return savePlaylist()
+}
@@ -0,0 +1,189 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="audio/media.m3u8"
+			#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+			video/media.m3u8
+		`)
+	})
+
+	mux.HandleFunc("/video/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-VERSION:6
+			#EXT-X-TARGETDURATION:4
+			#EXT-X-KEY:METHOD=AES-128,URI="/key"
+			#EXT-X-MAP:URI="init.mp4"
+			#EXTINF:3.14,
+			#EXT-X-BYTERANGE:1000@0
+			video.mp4
+			#EXTINF:3.14,
+			#EXT-X-BYTERANGE:1000
+			video.mp4
+			#EXT-X-ENDLIST
+		`)
+	})
+
+	mux.HandleFunc("/audio/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXTINF:3.14,
+			1.aac
+			#EXT-X-ENDLIST
+		`)
+	})
+
+	for _, path := range []string{"/key", "/video/init.mp4", "/audio/1.aac"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			w.Write(make([]byte, 16))
+		})
+	}
+	mux.HandleFunc("/video/video.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(206)
+		w.Write(make([]byte, 1000))
+	})
+
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := Client{
+		Client: srv.Client(),
+	}
+
+	master, err := h.ReadMasterPlaylist(context.Background(), srv.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.Mirror(context.Background(), master, master.Variants[0], dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"index.m3u8": `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="audio-0/index.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+video/index.m3u8
+`,
+		"video/index.m3u8": `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:4
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-KEY:METHOD=AES-128,URI="key-0.key"
+#EXT-X-MAP:URI="init-0.mp4"
+#EXTINF:3.14,
+0.mp4
+#EXTINF:3.14,
+1.mp4
+#EXT-X-ENDLIST
+`,
+		"audio-0/index.m3u8": `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXTINF:3.14,
+0.aac
+#EXT-X-ENDLIST
+`,
+	}
+
+	for name, content := range expected {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(actual) != content {
+			t.Errorf("%s expected\n%s\nfound\n%s", name, content, actual)
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Mode().Perm() != 0644 {
+			t.Errorf("%s: expected mode 0644, found %v", name, info.Mode().Perm())
+		}
+	}
+
+	for _, name := range []string{"video/key-0.key", "video/init-0.mp4", "video/0.mp4", "video/1.mp4", "audio-0/0.aac"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMirrorDiscontinuitySequence(t *testing.T) {
+	var tests = []struct {
+		discontinuities []int
+		expected        string
+	}{
+		// the first segment mirrored is 12
+		{[]int{10}, "#EXT-X-DISCONTINUITY-SEQUENCE:3\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXTINF:4,\n12.ts\n"},
+		{[]int{12}, "#EXT-X-DISCONTINUITY-SEQUENCE:2\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-DISCONTINUITY\n#EXTINF:4,\n12.ts\n"},
+		{[]int{10, 12}, "#EXT-X-DISCONTINUITY-SEQUENCE:3\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-DISCONTINUITY\n#EXTINF:4,\n12.ts\n"},
+	}
+
+	for _, tt := range tests {
+		playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n#EXT-X-DISCONTINUITY-SEQUENCE:2\n#EXT-X-START:TIME-OFFSET=8\n"
+		for seq := 10; seq < 14; seq++ {
+			for _, d := range tt.discontinuities {
+				if d == seq {
+					playlist += "#EXT-X-DISCONTINUITY\n"
+				}
+			}
+			playlist += fmt.Sprintf("#EXTINF:4,\n%d.ts\n", seq)
+		}
+		playlist += "#EXT-X-ENDLIST\n"
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/media.m3u8" {
+				io.WriteString(w, playlist)
+			} else {
+				w.Write(make([]byte, 16))
+			}
+		}))
+		dir, err := ioutil.TempDir("", "mirror")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := Client{Client: srv.Client()}
+		if err := h.mirrorMedia(context.Background(), srv.URL+"/media.m3u8", dir); err != nil {
+			t.Fatal(err)
+		}
+		mirrored, err := ioutil.ReadFile(filepath.Join(dir, "index.m3u8"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(mirrored), tt.expected) {
+			t.Errorf("discontinuities on %v: expected\n%s\nin\n%s", tt.discontinuities, tt.expected, mirrored)
+		}
+
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
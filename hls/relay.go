@@ -0,0 +1,348 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// Relay is a SegmentSink that keeps the segments it is given for a while and
+// serves them again as a live stream, so that a stream is downloaded once
+// and watched by many.  Use it with DownloadSink and serve its Handler.
+//
+// Segments are numbered by the Relay, from zero, so that segments skipped at
+// the origin leave no hole in the Media Sequence Numbers; a discontinuity is
+// marked instead.
+//
+// The EXT-X-MAP of segments is fetched and served by the Relay, always from
+// memory.  Encrypted segments are relayed with their EXT-X-KEY as it is, so
+// that players get the key from the origin.
+type Relay struct {
+	// Window is how much of the stream is kept, and how far back players
+	// can seek; zero means 5 minutes.
+	Window time.Duration
+
+	// Dir, if not empty, is where segments are kept instead of in memory.
+	Dir string
+
+	// Variant, if not nil, describes the stream in the Master Playlist;
+	// otherwise only its peak bandwidth is given.
+	Variant *m3u8.Variant
+
+	mu               sync.Mutex
+	segments         []*relaySegment
+	maps             map[m3u8.Map]*relayMap
+	nextSeq          uint64
+	discontinuitySeq uint64
+	targetDuration   time.Duration
+	closed           bool
+
+	// current is the segment being written, and originSeq the Media
+	// Sequence Number of the last segment at the origin.
+	current   *relaySegment
+	originSeq uint64
+	started   bool
+}
+
+type relaySegment struct {
+	*MediaSegment
+	name  string
+	bytes int64
+	data  []byte   // if kept in memory
+	file  *os.File // while it is written to Dir
+}
+
+type relayMap struct {
+	name string
+	data []byte
+}
+
+func (r *Relay) window() time.Duration {
+	if r.Window <= 0 {
+		return 5 * time.Minute
+	}
+	return r.Window
+}
+
+func (r *Relay) hasMap(m m3u8.Map) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.maps[m]
+	return ok
+}
+
+func (r *Relay) keepMap(m m3u8.Map, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maps == nil {
+		r.maps = make(map[m3u8.Map]*relayMap)
+	}
+	r.maps[m] = &relayMap{
+		name: fmt.Sprintf("init-%d%s", len(r.maps), extension(m.URI, ".mp4")),
+		data: data,
+	}
+	return nil
+}
+
+func (r *Relay) BeginSegment(meta SegmentMeta) error {
+	r.mu.Lock()
+	seq := r.nextSeq
+	xmap := meta.Map
+	if xmap != nil {
+		// one that was not kept is left to the origin
+		if m, ok := r.maps[*xmap]; ok {
+			xmap = &m3u8.Map{URI: "maps/" + m.name}
+		}
+	}
+	r.mu.Unlock()
+
+	seg := &relaySegment{
+		MediaSegment: &MediaSegment{MediaSegment: &m3u8.MediaSegment{
+			SeqId:           seq,
+			Duration:        meta.Duration.Seconds(),
+			Discontinuity:   meta.Discontinuity || (r.started && meta.SeqId != r.originSeq+1),
+			ProgramDateTime: meta.ProgramDateTime,
+			Key:             meta.Key,
+			Map:             xmap,
+		}},
+		name: fmt.Sprintf("%d%s", seq, extension(meta.URI, ".ts")),
+	}
+	seg.URI = "segments/" + seg.name
+	r.current, r.originSeq, r.started = seg, meta.SeqId, true
+
+	if r.Dir != "" {
+		var err error
+		seg.file, err = os.Create(filepath.Join(r.Dir, seg.name))
+		return err
+	}
+	return nil
+}
+
+func (r *Relay) Write(p []byte) (int, error) {
+	seg := r.current
+	seg.bytes += int64(len(p))
+	if seg.file != nil {
+		return seg.file.Write(p)
+	}
+	seg.data = append(seg.data, p...)
+	return len(p), nil
+}
+
+func (r *Relay) EndSegment(err error) error {
+	seg := r.current
+	r.current = nil
+	if err != nil {
+		// the segment is dropped
+		if seg.file != nil {
+			seg.file.Close()
+			os.Remove(seg.file.Name())
+		}
+		return nil
+	}
+	if seg.file != nil {
+		if err := seg.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.segments = append(r.segments, seg)
+	r.nextSeq++
+	if d := time.Duration(seg.Duration * 1e9); d > r.targetDuration {
+		r.targetDuration = d
+	}
+
+	var kept time.Duration
+	for _, s := range r.segments {
+		kept += time.Duration(s.Duration * 1e9)
+	}
+	for len(r.segments) > 1 {
+		first := r.segments[0]
+		if kept-time.Duration(first.Duration*1e9) < r.window() {
+			break
+		}
+		kept -= time.Duration(first.Duration * 1e9)
+		r.segments = r.segments[1:]
+
+		// § 6.2.2
+		// If the server removes an EXT-X-DISCONTINUITY tag from the Media
+		// Playlist, it MUST increment the value of the
+		// EXT-X-DISCONTINUITY-SEQUENCE tag so that the Discontinuity
+		// Sequence Numbers of the segments still in the Media Playlist
+		// remain unchanged.
+		if first.Discontinuity {
+			r.discontinuitySeq++
+		}
+		if r.Dir != "" {
+			os.Remove(filepath.Join(r.Dir, first.name))
+		}
+	}
+	return nil
+}
+
+// Close ends the stream; EXT-X-ENDLIST is added to the Media Playlist.
+func (r *Relay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+// Playlist returns the Media Playlist of the segments kept, or nil if there
+// are none yet.
+func (r *Relay) Playlist() *MediaPlaylist {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.segments) == 0 {
+		return nil
+	}
+
+	media := &MediaPlaylist{
+		TargetDuration: r.targetDuration,
+		MediaPlaylist: &m3u8.MediaPlaylist{
+			SeqNo:            r.segments[0].SeqId,
+			DiscontinuitySeq: r.discontinuitySeq,
+			Closed:           r.closed,
+		},
+	}
+	version := uint8(3)
+	for _, seg := range r.segments {
+		media.Segments = append(media.Segments, seg.MediaSegment)
+
+		// § 7
+		// A Media Playlist MUST indicate an EXT-X-VERSION of 5 or higher if
+		// it contains: The KEYFORMAT and KEYFORMATVERSIONS attributes of the
+		// EXT-X-KEY tag.
+		//
+		// A Media Playlist MUST indicate an EXT-X-VERSION of 6 or higher if
+		// it contains: The EXT-X-MAP tag in a Media Playlist that does not
+		// contain EXT-X-I-FRAMES-ONLY.
+		if seg.Map != nil {
+			version = 6
+		} else if seg.Key != nil && (seg.Key.Keyformat != "" || seg.Key.Keyformatversions != "") && version < 5 {
+			version = 5
+		}
+	}
+	media.SetVersion(version)
+	return media
+}
+
+func (r *Relay) masterPlaylist() *MasterPlaylist {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var v m3u8.Variant
+	if r.Variant != nil {
+		v = *r.Variant
+		// renditions are not relayed
+		v.Alternatives = nil
+		v.Audio, v.Video, v.Subtitles, v.Captions = "", "", "", ""
+	}
+	if v.Bandwidth == 0 {
+		// § 4.3.4.2: the peak segment bit rate
+		for _, seg := range r.segments {
+			if bandwidth := uint32(float64(seg.bytes*8) / seg.Duration); seg.Duration > 0 && bandwidth > v.Bandwidth {
+				v.Bandwidth = bandwidth
+			}
+		}
+	}
+	v.URI = "live.m3u8"
+	return &MasterPlaylist{MasterPlaylist: &m3u8.MasterPlaylist{Variants: []*m3u8.Variant{&v}}}
+}
+
+func (r *Relay) initMap(name string) *relayMap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.maps {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func (r *Relay) segment(name string) *relaySegment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, seg := range r.segments {
+		if seg.name == name {
+			return seg
+		}
+	}
+	return nil
+}
+
+// Handler serves the stream kept by r:
+//
+//	GET /master.m3u8       a Master Playlist with the relayed variant
+//	GET /live.m3u8         the Media Playlist of the segments kept
+//	GET /segments/{name}   a segment
+//	GET /maps/{name}       the EXT-X-MAP of segments
+func (r *Relay) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	writePlaylist := func(w http.ResponseWriter, encode func(buf *bytes.Buffer) error) {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(buf.Bytes())
+	}
+
+	mux.HandleFunc("GET /master.m3u8", func(w http.ResponseWriter, req *http.Request) {
+		writePlaylist(w, func(buf *bytes.Buffer) error {
+			return r.masterPlaylist().EncodeTo(buf, nil)
+		})
+	})
+
+	mux.HandleFunc("GET /live.m3u8", func(w http.ResponseWriter, req *http.Request) {
+		media := r.Playlist()
+		if media == nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "no segments yet", http.StatusServiceUnavailable)
+			return
+		}
+		writePlaylist(w, func(buf *bytes.Buffer) error {
+			return media.EncodeTo(buf, nil)
+		})
+	})
+
+	mux.HandleFunc("GET /segments/{name}", func(w http.ResponseWriter, req *http.Request) {
+		seg := r.segment(req.PathValue("name"))
+		if seg == nil {
+			// it may have left the window
+			http.NotFound(w, req)
+			return
+		}
+		if r.Dir != "" {
+			http.ServeFile(w, req, filepath.Join(r.Dir, seg.name))
+			return
+		}
+		http.ServeContent(w, req, seg.name, time.Time{}, bytes.NewReader(seg.data))
+	})
+
+	mux.HandleFunc("GET /maps/{name}", func(w http.ResponseWriter, req *http.Request) {
+		m := r.initMap(req.PathValue("name"))
+		if m == nil {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, m.name, time.Time{}, bytes.NewReader(m.data))
+	})
+
+	return mux
+}
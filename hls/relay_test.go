@@ -0,0 +1,171 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam/hls/hlstest"
+)
+
+func TestRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, dir := range []string{"", dir} {
+		r := &Relay{Window: 10 * time.Second, Dir: dir}
+		srv := httptest.NewServer(r.Handler())
+
+		get := func(path string) (*http.Response, string) {
+			resp, err := srv.Client().Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			return resp, string(body)
+		}
+		media := func() *MediaPlaylist {
+			resp, body := get("/live.m3u8")
+			if resp.StatusCode != 200 {
+				t.Fatalf("expected the live playlist, found %s", resp.Status)
+			}
+			playlist, _, err := parseM3U8(strings.NewReader(body), srv.URL+"/live.m3u8")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return playlist.(*MediaPlaylist)
+		}
+		feed := func(originSeq uint64, discontinuity bool) {
+			r.BeginSegment(SegmentMeta{
+				SeqId:         originSeq,
+				URI:           fmt.Sprintf("http://origin/%d.ts", originSeq),
+				Duration:      4 * time.Second,
+				Discontinuity: discontinuity,
+			})
+			r.Write(make([]byte, 100))
+			if err := r.EndSegment(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if resp, _ := get("/live.m3u8"); resp.StatusCode != 503 {
+			t.Errorf("expected %d before any segment, found %s", 503, resp.Status)
+		}
+
+		// origin segment 8 is skipped
+		for _, seq := range []uint64{5, 6, 7, 9} {
+			feed(seq, false)
+		}
+
+		playlist := media()
+		if playlist.SeqNo != 1 || len(playlist.Segments) != 3 || playlist.Closed {
+			t.Fatalf("expected segments 1 to 3 of a live playlist, found %d from %d", len(playlist.Segments), playlist.SeqNo)
+		}
+		for i, seg := range playlist.Segments {
+			if seg.SeqId != uint64(i+1) || seg.Discontinuity != (i == 2) {
+				t.Errorf("unexpected segment %d: %+v", i, seg.MediaSegment)
+			}
+		}
+		if resp, _ := get("/segments/0.ts"); resp.StatusCode != 404 {
+			t.Errorf("expected a segment that left the window to be gone, found %s", resp.Status)
+		}
+		if resp, body := get("/segments/3.ts"); resp.StatusCode != 200 || len(body) != 100 {
+			t.Errorf("expected 100 bytes, found %s and %d bytes", resp.Status, len(body))
+		}
+
+		_, body := get("/master.m3u8")
+		master, _, err := parseM3U8(strings.NewReader(body), srv.URL+"/master.m3u8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := master.(*MasterPlaylist).Variants[0]; v.URI != srv.URL+"/live.m3u8" || v.Bandwidth != 200 {
+			t.Errorf("unexpected variant %+v", v.VariantParams)
+		}
+
+		// the discontinuity leaves the window
+		for _, seq := range []uint64{10, 11, 12} {
+			feed(seq, false)
+		}
+		r.Close()
+		playlist = media()
+		if playlist.SeqNo != 4 || playlist.DiscontinuitySeq != 1 || !playlist.Closed {
+			t.Errorf("expected segments from 4, discontinuity sequence 1, closed; found %d, %d, %v",
+				playlist.SeqNo, playlist.DiscontinuitySeq, playlist.Closed)
+		}
+		srv.Close()
+	}
+}
+
+func TestRelayMapAndKey(t *testing.T) {
+	var mapRequests int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/media.m3u8":
+			io.WriteString(w, `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:4
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4,
+0.mp4
+#EXTINF:4,
+1.mp4
+#EXT-X-ENDLIST
+`)
+		case "/init.mp4":
+			mapRequests++
+			io.WriteString(w, "init")
+		default:
+			io.WriteString(w, "segment")
+		}
+	}))
+	defer origin.Close()
+
+	r := &Relay{}
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	h := Client{
+		Client: origin.Client(),
+		Clock:  hlstest.NewAutoClock(time.Now()),
+	}
+	if _, err := h.DownloadSink(context.Background(), origin.URL+"/media.m3u8", r); err != nil {
+		t.Fatal(err)
+	}
+	if mapRequests != 1 {
+		t.Errorf("expected the map to be fetched once, found %d requests", mapRequests)
+	}
+
+	playlist := r.Playlist()
+	if playlist.Version() != 6 || len(playlist.Segments) != 2 {
+		t.Fatalf("expected 2 segments in a playlist of version 6, found %d in version %d", len(playlist.Segments), playlist.Version())
+	}
+	for _, seg := range playlist.Segments {
+		if seg.Map == nil || seg.Map.URI != "maps/init-0.mp4" {
+			t.Errorf("expected the map to be served by the relay, found %+v", seg.Map)
+		}
+		if seg.Key == nil || seg.Key.URI != origin.URL+"/key.bin" {
+			t.Errorf("expected the key of the origin, found %+v", seg.Key)
+		}
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/maps/init-0.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != 200 || string(body) != "init" {
+		t.Errorf("expected the map, found %s and %q", resp.Status, body)
+	}
+}
@@ -0,0 +1,206 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"github.com/otommod/go-dam"
+)
+
+// SegmentMeta describes a segment to a SegmentSink.
+type SegmentMeta struct {
+	SeqId    uint64
+	URI      string
+	Duration time.Duration
+
+	Discontinuity   bool      // EXT-X-DISCONTINUITY
+	ProgramDateTime time.Time // EXT-X-PROGRAM-DATE-TIME, or zero
+
+	// Key and Map are the EXT-X-KEY and EXT-X-MAP that apply to the
+	// segment, if any.  Neither is fetched; a sink that is given an
+	// encrypted segment gets it as it was served.
+	Key *m3u8.Key
+	Map *m3u8.Map
+}
+
+// SegmentSink receives the segments of a download, one after the other.  The
+// data of each segment is written between its BeginSegment and EndSegment,
+// which is called whenever BeginSegment succeeded.  The err given to
+// EndSegment is not nil if the segment could not be completed, and the sink
+// is then to drop what it has of it.  An error from any of them stops the
+// download.
+type SegmentSink interface {
+	BeginSegment(meta SegmentMeta) error
+	io.Writer
+	EndSegment(err error) error
+}
+
+func segmentMeta(seg *MediaSegment) SegmentMeta {
+	return SegmentMeta{
+		SeqId:           seg.SeqId,
+		URI:             seg.URI,
+		Duration:        time.Duration(seg.Duration * 1e9),
+		Discontinuity:   seg.Discontinuity,
+		ProgramDateTime: seg.ProgramDateTime,
+		Key:             seg.Key,
+		Map:             seg.Map,
+	}
+}
+
+type concatSink struct {
+	io.Writer
+}
+
+// ConcatSink writes the segments to w one after the other, which is how
+// MPEG-TS segments make up a single stream.  It refuses encrypted segments
+// and segments with an EXT-X-MAP, whose data alone does not play.
+func ConcatSink(w io.Writer) SegmentSink {
+	return concatSink{w}
+}
+
+// refuser is implemented by sinks that refuse some segments, so that those
+// are refused before they are fetched.
+type refuser interface {
+	refuse(meta SegmentMeta) error
+}
+
+// mapKeeper is implemented by sinks that keep the EXT-X-MAP of segments,
+// which is then fetched for them before the first segment it applies to.
+type mapKeeper interface {
+	hasMap(m m3u8.Map) bool
+	keepMap(m m3u8.Map, data []byte) error
+}
+
+func (concatSink) refuse(meta SegmentMeta) error {
+	if meta.Key != nil {
+		return dam.NotSupportedError{Feature: "EXT-X-KEY"}
+	}
+	if meta.Map != nil {
+		return dam.NotSupportedError{Feature: "EXT-X-MAP"}
+	}
+	return nil
+}
+
+func (s concatSink) BeginSegment(meta SegmentMeta) error {
+	return s.refuse(meta)
+}
+
+func (concatSink) EndSegment(err error) error {
+	return nil
+}
+
+type dirSink struct {
+	dir      string
+	tmp      *os.File
+	filename string
+}
+
+// DirSink writes each segment to a file of its own in dir, named after its
+// Media Sequence Number and with the extension of its URI, like 42.ts.  A
+// file appears only once its segment is complete.
+func DirSink(dir string) SegmentSink {
+	return &dirSink{dir: dir}
+}
+
+func (s *dirSink) BeginSegment(meta SegmentMeta) error {
+	s.filename = filepath.Join(s.dir, fmt.Sprintf("%d%s", meta.SeqId, extension(meta.URI, ".ts")))
+	tmp, err := ioutil.TempFile(s.dir, "."+filepath.Base(s.filename))
+	s.tmp = tmp
+	return err
+}
+
+func (s *dirSink) Write(p []byte) (int, error) {
+	return s.tmp.Write(p)
+}
+
+func (s *dirSink) EndSegment(err error) error {
+	defer os.Remove(s.tmp.Name())
+	if err == nil {
+		// TempFile makes files that only their owner can read
+		if err := s.tmp.Chmod(0644); err != nil {
+			s.tmp.Close()
+			return err
+		}
+	}
+	if closeErr := s.tmp.Close(); closeErr != nil || err != nil {
+		return closeErr
+	}
+	return os.Rename(s.tmp.Name(), s.filename)
+}
+
+type teeSink []SegmentSink
+
+// TeeSink hands every segment to each of sinks, in order.
+func TeeSink(sinks ...SegmentSink) SegmentSink {
+	return teeSink(sinks)
+}
+
+func (t teeSink) refuse(meta SegmentMeta) error {
+	for _, s := range t {
+		if r, ok := s.(refuser); ok {
+			if err := r.refuse(meta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t teeSink) hasMap(m m3u8.Map) bool {
+	for _, s := range t {
+		if k, ok := s.(mapKeeper); ok && !k.hasMap(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t teeSink) keepMap(m m3u8.Map, data []byte) error {
+	for _, s := range t {
+		if k, ok := s.(mapKeeper); ok && !k.hasMap(m) {
+			if err := k.keepMap(m, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t teeSink) BeginSegment(meta SegmentMeta) error {
+	for i, s := range t {
+		if err := s.BeginSegment(meta); err != nil {
+			for _, began := range t[:i] {
+				began.EndSegment(err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeSink) Write(p []byte) (int, error) {
+	for _, s := range t {
+		if n, err := s.Write(p); err != nil {
+			return n, err
+		} else if n != len(p) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return len(p), nil
+}
+
+// EndSegment ends the segment in every sink, even if some fail to.
+func (t teeSink) EndSegment(err error) error {
+	var first error
+	for _, s := range t {
+		if endErr := s.EndSegment(err); endErr != nil && first == nil {
+			first = endErr
+		}
+	}
+	return first
+}
@@ -0,0 +1,139 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps what it is given.  If failWrite is not nil, writes
+// fail with it.
+type recordingSink struct {
+	metas     []SegmentMeta
+	data      [][]byte
+	ended     int
+	dropped   int
+	failWrite error
+}
+
+func (s *recordingSink) BeginSegment(meta SegmentMeta) error {
+	s.metas = append(s.metas, meta)
+	s.data = append(s.data, nil)
+	return nil
+}
+
+func (s *recordingSink) Write(p []byte) (int, error) {
+	if s.failWrite != nil {
+		return 0, s.failWrite
+	}
+	s.data[len(s.data)-1] = append(s.data[len(s.data)-1], p...)
+	return len(p), nil
+}
+
+func (s *recordingSink) EndSegment(err error) error {
+	if err != nil {
+		s.dropped++
+	} else {
+		s.ended++
+	}
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/media.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, `
+			#EXTM3U
+			#EXT-X-TARGETDURATION:4
+			#EXT-X-MEDIA-SEQUENCE:10
+			#EXT-X-PROGRAM-DATE-TIME:2020-03-04T19:00:00Z
+			#EXTINF:4,
+			a.ts
+			#EXT-X-DISCONTINUITY
+			#EXT-X-KEY:METHOD=AES-128,URI="key"
+			#EXTINF:2.5,
+			b.ts
+			#EXT-X-ENDLIST
+		`)
+	})
+	mux.HandleFunc("/a.ts", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+	})
+	mux.HandleFunc("/b.ts", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "second")
+	})
+
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var recorded recordingSink
+	h := Client{Client: srv.Client()}
+	report, err := h.DownloadSink(context.Background(), srv.URL+"/media.m3u8", TeeSink(DirSink(dir), &recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Segments != 2 || recorded.ended != 2 {
+		t.Fatalf("expected 2 segments, found %+v and %d ended", report, recorded.ended)
+	}
+
+	first, second := recorded.metas[0], recorded.metas[1]
+	if first.SeqId != 10 || first.Duration != 4*time.Second || first.Discontinuity || first.Key != nil {
+		t.Errorf("unexpected first segment %+v", first)
+	}
+	if !first.ProgramDateTime.Equal(time.Date(2020, 3, 4, 19, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected a program date time, found %v", first.ProgramDateTime)
+	}
+	if second.SeqId != 11 || second.Duration != 2500*time.Millisecond || !second.Discontinuity || second.Key == nil {
+		t.Errorf("unexpected second segment %+v", second)
+	}
+	if string(recorded.data[0]) != "first" || string(recorded.data[1]) != "second" {
+		t.Errorf("expected %q and %q, found %q", "first", "second", recorded.data)
+	}
+
+	for name, expected := range map[string]string{"10.ts": "first", "11.ts": "second"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != expected {
+			t.Errorf("%s: expected %q, found %q (%v)", name, expected, data, err)
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Mode().Perm() != 0644 {
+			t.Errorf("%s: expected mode 0644, found %v", name, info.Mode().Perm())
+		}
+	}
+
+	var buf bytes.Buffer
+	_, err = h.DownloadSink(context.Background(), srv.URL+"/media.m3u8", ConcatSink(&buf))
+	if err == nil || buf.String() != "first" {
+		t.Errorf("expected the encrypted segment to be refused after %q, found %q (%v)", "first", buf.String(), err)
+	}
+
+	// A segment that fails half way leaves nothing behind.
+	failDir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(failDir)
+
+	failing := &recordingSink{failWrite: errors.New("disk full")}
+	_, err = h.DownloadSink(context.Background(), srv.URL+"/media.m3u8", TeeSink(DirSink(failDir), failing))
+	if err != failing.failWrite || failing.dropped != 1 {
+		t.Errorf("expected the first segment to be dropped after %v, found %d dropped (%v)", failing.failWrite, failing.dropped, err)
+	}
+	if files, _ := ioutil.ReadDir(failDir); len(files) != 0 {
+		t.Errorf("expected no files to be left, found %d, like %s", len(files), files[0].Name())
+	}
+}
@@ -0,0 +1,246 @@
+package icy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+// Metadata is a block of metadata interleaved in the audio, a list of
+// key='value'; pairs.
+type Metadata struct {
+	StreamTitle string
+	StreamURL   string
+
+	// Raw is the whole block, without its padding.
+	Raw string
+}
+
+func parseMetadata(block []byte) Metadata {
+	raw := string(bytes.TrimRight(block, "\x00"))
+	m := Metadata{Raw: raw}
+
+	// Values are not escaped, so a value ends only where a quote is
+	// followed by a semicolon.
+	for s := raw; s != ""; {
+		i := strings.Index(s, "='")
+		if i < 0 {
+			break
+		}
+		key := s[:i]
+		s = s[i+2:]
+
+		j := strings.Index(s, "';")
+		if j < 0 {
+			j = len(strings.TrimSuffix(s, "'"))
+			s += "';"
+		}
+		value := s[:j]
+		s = s[j+2:]
+
+		switch key {
+		case "StreamTitle":
+			m.StreamTitle = value
+		case "StreamUrl":
+			m.StreamURL = value
+		}
+	}
+	return m
+}
+
+// icyConn makes the responses of SHOUTcast 1 servers, whose status line is
+// "ICY 200 OK" instead of an HTTP one, readable by net/http.
+type icyConn struct {
+	net.Conn
+	checked bool
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		start := make([]byte, 3)
+		n, err := io.ReadFull(c.Conn, start)
+		if n == 3 && string(start) == "ICY" {
+			c.pending = []byte("HTTP/1.0")
+		} else {
+			c.pending = start[:n]
+		}
+		if err != nil && len(c.pending) == 0 {
+			return 0, err
+		}
+	}
+
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// Recorder records Icecast and SHOUTcast streams, which go on for as long as
+// the station does.
+type Recorder struct {
+	Client *http.Client
+
+	// Logger, if not nil, is where the progress of recordings is logged.
+	Logger *slog.Logger
+}
+
+func (r Recorder) logger() *slog.Logger {
+	return dam.Logger(r.Logger)
+}
+
+// client returns a copy of the Client of r that understands the responses of
+// SHOUTcast 1 servers, if its Transport can be changed to.
+func (r Recorder) client() *http.Client {
+	transport, ok := r.Client.Transport.(*http.Transport)
+	if r.Client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return r.Client
+	}
+
+	transport = transport.Clone()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	transport.DisableKeepAlives = true
+
+	client := *r.Client
+	client.Transport = transport
+	return &client
+}
+
+// receiver is where a recording goes.  connected is called with every
+// response, before any audio; metadata with every block of metadata whose
+// StreamTitle differs from the one before.
+type receiver interface {
+	io.Writer
+	connected(r *http.Response) error
+	metadata(m Metadata) error
+}
+
+// Record writes the audio of the ICY stream at uri to dst, stripped of the
+// metadata interleaved in it.  It reconnects, following the retry policy of
+// dam, whenever the connection drops, and returns only when ctx is done or
+// the server keeps failing.
+func (r Recorder) Record(ctx context.Context, uri string, dst io.Writer) error {
+	return r.record(ctx, uri, plainReceiver{dst})
+}
+
+type plainReceiver struct {
+	io.Writer
+}
+
+func (plainReceiver) connected(r *http.Response) error { return nil }
+func (plainReceiver) metadata(m Metadata) error        { return nil }
+
+func (r Recorder) record(ctx context.Context, uri string, dst receiver) error {
+	client := r.client()
+
+	var written int64
+	var title string
+	var titleSeen bool
+
+	attempt := func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+		req.Header.Set("Icy-MetaData", "1")
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return dam.RetryStatus(resp)
+		}
+		if err := dst.connected(resp); err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		metaint, _ := strconv.Atoi(resp.Header.Get("icy-metaint"))
+		if metaint <= 0 {
+			n, err := io.Copy(dst, resp.Body)
+			written += n
+			if err == nil && resp.ContentLength >= 0 {
+				// not a stream, but a file
+				return nil
+			} else if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		audio := make([]byte, metaint)
+		block := make([]byte, 255*16)
+		for {
+			n, err := io.ReadFull(resp.Body, audio)
+			if _, err := dst.Write(audio[:n]); err != nil {
+				return dam.StopRetrying(err)
+			}
+			written += int64(n)
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			} else if err != nil {
+				return err
+			}
+
+			if _, err := io.ReadFull(resp.Body, block[:1]); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			length := int(block[0]) * 16
+			if _, err := io.ReadFull(resp.Body, block[:length]); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			if length == 0 {
+				continue
+			}
+
+			m := parseMetadata(block[:length])
+			if titleSeen && m.StreamTitle == title {
+				continue
+			}
+			title, titleSeen = m.StreamTitle, true
+			r.logger().Debug("stream title", "uri", uri, "title", m.StreamTitle)
+			if err := dst.metadata(m); err != nil {
+				return dam.StopRetrying(err)
+			}
+		}
+	}
+
+	// Retry gives up after a while; as long as the stream keeps coming after
+	// reconnecting, it is given another while.
+	for {
+		before := written
+		err := dam.Retry(ctx, 90*time.Second, attempt)
+		if err == nil || ctx.Err() != nil {
+			return err
+		} else if written == before {
+			return err
+		}
+		r.logger().Warn("reconnecting", "uri", uri, "bytes", written, "err", err)
+	}
+}
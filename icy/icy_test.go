@@ -0,0 +1,187 @@
+package icy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		block    string
+		expected Metadata
+	}{
+		{
+			block: "StreamTitle='Artist - Title';StreamUrl='http://example.com/';\x00\x00\x00",
+			expected: Metadata{
+				StreamTitle: "Artist - Title",
+				StreamURL:   "http://example.com/",
+				Raw:         "StreamTitle='Artist - Title';StreamUrl='http://example.com/';",
+			},
+		},
+		{
+			block: "StreamTitle='Don't Stop';",
+			expected: Metadata{
+				StreamTitle: "Don't Stop",
+				Raw:         "StreamTitle='Don't Stop';",
+			},
+		},
+		{
+			block: "StreamTitle='Unterminated'",
+			expected: Metadata{
+				StreamTitle: "Unterminated",
+				Raw:         "StreamTitle='Unterminated'",
+			},
+		},
+		{
+			block:    "StreamTitle='';",
+			expected: Metadata{Raw: "StreamTitle='';"},
+		},
+	}
+
+	for _, test := range tests {
+		if actual := parseMetadata([]byte(test.block)); actual != test.expected {
+			t.Errorf("%q: expected %+v, found %+v", test.block, test.expected, actual)
+		}
+	}
+}
+
+// icyStream interleaves audio, in chunks of metaint bytes, with a block of
+// metadata for each title; an empty title makes an empty block.
+func icyStream(audio []string, titles []string) []byte {
+	var b bytes.Buffer
+	for i, a := range audio {
+		b.WriteString(a)
+		if titles[i] == "" {
+			b.WriteByte(0)
+			continue
+		}
+		m := fmt.Sprintf("StreamTitle='%s';", titles[i])
+		length := (len(m) + 15) / 16
+		b.WriteByte(byte(length))
+		b.WriteString(m)
+		b.Write(make([]byte, length*16-len(m)))
+	}
+	return b.Bytes()
+}
+
+// cancelWriter cancels a context once it has been written as much as
+// expected.
+type cancelWriter struct {
+	bytes.Buffer
+	expected int
+	cancel   func()
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if w.Len() >= w.expected {
+		w.cancel()
+	}
+	return n, err
+}
+
+func TestRecord(t *testing.T) {
+	var mu sync.Mutex
+	var connections int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Errorf("expected Icy-MetaData: 1, found %q", r.Header.Get("Icy-MetaData"))
+		}
+
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-metaint", "4")
+		w.WriteHeader(200)
+		if n == 1 {
+			// the connection drops in the middle of a block
+			w.Write(icyStream([]string{"aaaa", "bbbb"}, []string{"One", ""}))
+			w.Write([]byte("cc"))
+			return
+		}
+		w.Write(icyStream([]string{"dddd", "eeee"}, []string{"Two", ""}))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dst := &cancelWriter{expected: 18, cancel: cancel}
+
+	err := Recorder{Client: srv.Client()}.Record(ctx, srv.URL, dst)
+	if err != context.Canceled {
+		t.Errorf("expected %v, found %v", context.Canceled, err)
+	}
+	if expected := "aaaabbbbccddddeeee"; dst.String() != expected {
+		t.Errorf("expected %q, found %q", expected, dst.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != 2 {
+		t.Errorf("expected 2 connections, found %d", connections)
+	}
+}
+
+func TestRecordNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	// a missing stream is not tried again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var httpErr dam.HTTPError
+	if err := (Recorder{Client: srv.Client()}).Record(ctx, srv.URL, &bytes.Buffer{}); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Errorf("expected a 404, found %v", err)
+	}
+}
+
+func TestRecordICYStatusLine(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		http.ReadRequest(bufio.NewReader(conn))
+
+		fmt.Fprint(conn, "ICY 200 OK\r\nicy-name: Station\r\nicy-metaint: 4\r\n\r\n")
+		conn.Write(icyStream([]string{"aaaa", "bbbb"}, []string{"One", ""}))
+		buf := make([]byte, 1)
+		conn.Read(buf)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dst := &cancelWriter{expected: 8, cancel: cancel}
+
+	err = Recorder{Client: http.DefaultClient}.Record(ctx, "http://"+l.Addr().String()+"/", dst)
+	if err != context.Canceled {
+		t.Errorf("expected %v, found %v", context.Canceled, err)
+	}
+	if !strings.HasPrefix(dst.String(), "aaaabbbb") {
+		t.Errorf("expected %q, found %q", "aaaabbbb", dst.String())
+	}
+}
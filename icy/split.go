@@ -0,0 +1,159 @@
+package icy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extensions of the files of the audio types that stations send.
+var extensions = map[string]string{
+	"audio/mpeg":      ".mp3",
+	"audio/mp3":       ".mp3",
+	"audio/aac":       ".aac",
+	"audio/aacp":      ".aac",
+	"audio/ogg":       ".ogg",
+	"application/ogg": ".ogg",
+	"audio/flac":      ".flac",
+}
+
+// CueSheet is the name of the cue sheet that RecordSplit writes.
+const CueSheet = "tracks.cue"
+
+type track struct {
+	File      string
+	Performer string
+	Title     string
+}
+
+// splitter writes the audio of each title to its own file, and keeps a cue
+// sheet of them.
+type splitter struct {
+	dir     string
+	station string
+	ext     string
+
+	file   *os.File
+	title  string
+	tracks []track
+}
+
+func (s *splitter) connected(r *http.Response) error {
+	if s.station == "" {
+		s.station = r.Header.Get("icy-name")
+	}
+	if s.ext == "" {
+		s.ext = ".mp3"
+		if mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			if ext, ok := extensions[mediatype]; ok {
+				s.ext = ext
+			}
+		}
+	}
+	return nil
+}
+
+func (s *splitter) metadata(m Metadata) error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	s.title = m.StreamTitle
+	return nil
+}
+
+// fileName turns a title into a name of a file.
+func fileName(number int, title, ext string) string {
+	title = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	if title == "" {
+		return fmt.Sprintf("%03d%s", number, ext)
+	}
+	return fmt.Sprintf("%03d - %s%s", number, title, ext)
+}
+
+func (s *splitter) Write(p []byte) (int, error) {
+	if s.file == nil {
+		// Titles are usually "Artist - Title".
+		t := track{Title: s.title}
+		if i := strings.Index(s.title, " - "); i >= 0 {
+			t.Performer, t.Title = s.title[:i], s.title[i+3:]
+		}
+		t.File = fileName(len(s.tracks)+1, s.title, s.ext)
+
+		f, err := os.Create(filepath.Join(s.dir, t.File))
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		s.tracks = append(s.tracks, t)
+
+		// The cue sheet is rewritten with every track, so that it is
+		// complete however the recording ends.
+		if err := s.writeCueSheet(); err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(p)
+}
+
+func (s *splitter) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func cueString(s string) string {
+	return `"` + strings.Replace(s, `"`, "'", -1) + `"`
+}
+
+func (s *splitter) writeCueSheet() error {
+	fileType := "BINARY"
+	if s.ext == ".mp3" {
+		fileType = "MP3"
+	}
+
+	var b strings.Builder
+	if s.station != "" {
+		fmt.Fprintf(&b, "TITLE %s\n", cueString(s.station))
+	}
+	for i, t := range s.tracks {
+		fmt.Fprintf(&b, "FILE %s %s\n", cueString(t.File), fileType)
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+		if t.Performer != "" {
+			fmt.Fprintf(&b, "    PERFORMER %s\n", cueString(t.Performer))
+		}
+		if t.Title != "" {
+			fmt.Fprintf(&b, "    TITLE %s\n", cueString(t.Title))
+		}
+		fmt.Fprintf(&b, "    INDEX 01 00:00:00\n")
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, CueSheet), []byte(b.String()), 0666)
+}
+
+// RecordSplit records the ICY stream at uri like Record, but to a file in dir
+// for each StreamTitle, and writes a cue sheet of them, CueSheet.
+func (r Recorder) RecordSplit(ctx context.Context, uri string, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	s := &splitter{dir: dir}
+	err := r.record(ctx, uri, s)
+	if cerr := s.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
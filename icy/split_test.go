@@ -0,0 +1,79 @@
+package icy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordSplit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-name", "Station")
+		w.Header().Set("icy-metaint", "4")
+		w.WriteHeader(200)
+		w.Write(icyStream(
+			[]string{"xxxx", "aaaa", "aaaa", "bbbb"},
+			[]string{"Artist - One", "", "Two", "Two"}))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "icy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- Recorder{Client: srv.Client()}.RecordSplit(ctx, srv.URL, dir)
+	}()
+	for {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, "003 - Two.mp3")); string(data) == "bbbb" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected %v, found %v", context.Canceled, err)
+	}
+
+	files := map[string]string{
+		"001.mp3":                "xxxx",
+		"002 - Artist - One.mp3": "aaaaaaaa",
+		"003 - Two.mp3":          "bbbb",
+		CueSheet: `TITLE "Station"
+FILE "001.mp3" MP3
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+FILE "002 - Artist - One.mp3" MP3
+  TRACK 02 AUDIO
+    PERFORMER "Artist"
+    TITLE "One"
+    INDEX 01 00:00:00
+FILE "003 - Two.mp3" MP3
+  TRACK 03 AUDIO
+    TITLE "Two"
+    INDEX 01 00:00:00
+`,
+	}
+	for name, expected := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != expected {
+			t.Errorf("%s: expected\n%s\nfound\n%s", name, expected, data)
+		}
+	}
+}
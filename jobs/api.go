@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Handler serves the API of m:
+//
+//	POST /jobs              submits a job, given a Request
+//	GET  /jobs              lists the jobs
+//	GET  /jobs/{id}         returns a job, with its progress
+//	POST /jobs/{id}/cancel  cancels a job
+//	GET  /jobs/{id}/report  returns the Report of a job that ran
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job, err := m.Submit(req)
+		if errors.Is(err, ErrInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	})
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Jobs())
+	})
+
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := m.Job(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("POST /jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := m.Cancel(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("GET /jobs/{id}/report", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := m.Job(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if job.Report == nil {
+			http.Error(w, "job "+job.ID+" has no report", http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, job.Report)
+	})
+
+	return mux
+}
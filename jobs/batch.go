@@ -0,0 +1,179 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/otommod/go-dam"
+	"github.com/otommod/go-dam/hls"
+)
+
+// Entry is a line of a batch file: a URL and, optionally, the output file
+// and the format, separated by whitespace.  The output may be a
+// dam.Template; "-" keeps the default, dam.DefaultTemplate.
+type Entry struct {
+	URL    string
+	Output string
+	Format string
+}
+
+// ParseBatch reads the entries of a batch file, skipping blank lines and
+// lines that begin with #.
+func ParseBatch(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		} else if len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected a URL, an output and a format, found %d fields", line, len(fields))
+		}
+
+		e := Entry{URL: fields[0], Output: string(dam.DefaultTemplate), Format: "best"}
+		if len(fields) > 1 && fields[1] != "-" {
+			e.Output = fields[1]
+		}
+		if len(fields) > 2 {
+			e.Format = fields[2]
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Result is the outcome of an Entry, whose Output is expanded if it was a
+// template.  Skipped is set if its output existed already.
+type Result struct {
+	Entry
+	Skipped bool
+	Report  *Report
+	Err     error
+}
+
+// Batch downloads many entries at once, Concurrency at a time and at most
+// PerHost at a time from the same host.  Entries are downloaded to a .part
+// file that is renamed once complete, so that an output that exists is
+// known to be complete and is skipped.
+type Batch struct {
+	Client *http.Client
+	Dir    string
+
+	// Concurrency is how many entries are downloaded at once; zero means
+	// DefaultConcurrency.
+	Concurrency int
+
+	// PerHost is how many of them may be from the same host; zero means 2.
+	PerHost int
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+
+	// OnEvent, if not nil, returns what the events of the HLS entry of uri
+	// are given to, like metrics.Collector.Stream.
+	OnEvent func(uri string) func(hls.Event)
+}
+
+// Run downloads entries and returns their results, in the same order.
+func (b Batch) Run(ctx context.Context, entries []Entry) []Result {
+	concurrency := concurrency(b.Concurrency)
+	perHost := b.PerHost
+	if perHost <= 0 {
+		perHost = 2
+	}
+
+	slots := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	hosts := make(map[string]chan struct{})
+	hostSlots := func(host string) chan struct{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := hosts[host]; !ok {
+			hosts[host] = make(chan struct{}, perHost)
+		}
+		return hosts[host]
+	}
+
+	results := make([]Result, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		results[i].Entry = e
+
+		if !filepath.IsLocal(e.Output) {
+			results[i].Err = invalidRequestError("output must be a relative path inside the directory")
+			continue
+		}
+		// An output that is a template can only be told, and skipped, once
+		// the entry is resolved.
+		if !dam.IsTemplate(e.Output) {
+			if _, err := os.Stat(filepath.Join(b.Dir, e.Output)); err == nil {
+				results[i].Skipped = true
+				continue
+			}
+		}
+
+		var host string
+		if u, err := url.Parse(e.URL); err == nil {
+			host = u.Host
+		}
+
+		wg.Add(1)
+		go func(r *Result) {
+			defer wg.Done()
+
+			// A slot of the host is taken first, so that entries that wait
+			// for their host do not hold global slots meanwhile.
+			hostSlot := hostSlots(host)
+			select {
+			case hostSlot <- struct{}{}:
+			case <-ctx.Done():
+				r.Err = ctx.Err()
+				return
+			}
+			defer func() { <-hostSlot }()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				r.Err = ctx.Err()
+				return
+			}
+			defer func() { <-slots }()
+
+			d, format, err := resolve(ctx, b.Client, b.Logger, r.URL, r.Format)
+			if err != nil {
+				r.Err = err
+				return
+			}
+			if r.Output, r.Err = expand(ctx, d, r.URL, format, r.Output); r.Err != nil {
+				return
+			}
+			filename := filepath.Join(b.Dir, filepath.FromSlash(r.Output))
+			if _, err := os.Stat(filename); err == nil {
+				r.Skipped = true
+				return
+			}
+
+			var onEvent func(hls.Event)
+			if b.OnEvent != nil {
+				onEvent = b.OnEvent(r.URL)
+			}
+
+			dam.Logger(b.Logger).Info("downloading", "uri", r.URL, "file", filename)
+			r.Report, r.Err = download(ctx, d, r.URL, format, filename+".part", nil, onEvent)
+			if r.Err == nil {
+				r.Err = os.Rename(filename+".part", filename)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func TestParseBatch(t *testing.T) {
+	entries, err := ParseBatch(strings.NewReader(`
+		# archive
+		http://example.com/a/video.mp4
+		http://example.com/b/index.m3u8  b.ts
+		http://example.com/c/manifest.mpd  -  worst
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{
+		{URL: "http://example.com/a/video.mp4", Output: "{name}.{ext}", Format: "best"},
+		{URL: "http://example.com/b/index.m3u8", Output: "b.ts", Format: "best"},
+		{URL: "http://example.com/c/manifest.mpd", Output: "{name}.{ext}", Format: "worst"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, found %+v", expected, entries)
+	}
+
+	if _, err := ParseBatch(strings.NewReader("http://example.com/ a b c")); err == nil {
+		t.Error("expected an error for a line with too many fields")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/encrypted.m3u8" {
+			w.WriteHeader(200)
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:4,\nseg.ts\n#EXT-X-ENDLIST\n"))
+			return
+		}
+		w.WriteHeader(200)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "existing.bin"), []byte("done before"), 0644)
+
+	var entries []Entry
+	for _, name := range []string{"1.bin", "2.bin", "3.bin", "4.bin", "encrypted.m3u8", "existing.bin"} {
+		entries = append(entries, Entry{URL: srv.URL + "/" + name, Output: name, Format: "best"})
+	}
+	entries = append(entries, Entry{URL: srv.URL + "/templated.bin", Output: "t/{name}-{id}.{ext}", Format: "best"})
+
+	b := Batch{Client: srv.Client(), Dir: dir, Concurrency: 4, PerHost: 2}
+	results := b.Run(context.Background(), entries)
+
+	if maxActive > 2 {
+		t.Errorf("expected at most 2 requests to the host at once, found %d", maxActive)
+	}
+
+	for _, r := range results[:4] {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.URL, r.Err)
+			continue
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, r.Output))
+		if string(data) != "/"+r.Output {
+			t.Errorf("%s: expected %q, found %q", r.URL, "/"+r.Output, data)
+		}
+	}
+
+	if !errors.Is(results[4].Err, dam.ErrNotSupported) {
+		t.Errorf("expected %v, found %v", dam.ErrNotSupported, results[4].Err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "encrypted.m3u8")); err == nil {
+		t.Error("expected no output for a failed entry")
+	}
+
+	if !results[5].Skipped {
+		t.Errorf("expected %s to be skipped", results[5].Output)
+	}
+
+	if results[6].Err != nil || results[6].Output != "t/templated-0.bin" {
+		t.Errorf("expected %q, found %q (%v)", "t/templated-0.bin", results[6].Output, results[6].Err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "t", "templated-0.bin")); string(data) != "/templated.bin" {
+		t.Errorf("expected %q, found %q", "/templated.bin", data)
+	}
+}
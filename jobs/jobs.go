@@ -0,0 +1,474 @@
+// Package jobs runs downloads in the background, as jobs that are queued,
+// followed and canceled through an HTTP API, and that survive restarts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/otommod/go-dam"
+	"github.com/otommod/go-dam/dash"
+	"github.com/otommod/go-dam/hds"
+	"github.com/otommod/go-dam/hls"
+	"github.com/otommod/go-dam/smooth"
+)
+
+// StateFile is the name of the file in the directory of a Manager that its
+// jobs are kept in.
+const StateFile = "jobs.json"
+
+type State string
+
+const (
+	Queued   State = "queued"
+	Running  State = "running"
+	Done     State = "done"
+	Failed   State = "failed"
+	Canceled State = "canceled"
+)
+
+// Request is what a job is asked to download.
+type Request struct {
+	URL string
+
+	// Format selects the format, like dam.SelectFormat; empty means best.
+	Format string
+
+	// Output is the file to download to, relative to the directory of the
+	// Manager.  It may be a dam.Template.
+	Output string
+
+	// StartAt and StopAt, if not zero, limit a job to a time range; it waits
+	// until StartAt to begin and is stopped, successfully, at StopAt.  They
+	// are meant for recording live streams.
+	StartAt time.Time
+	StopAt  time.Time
+}
+
+// Report is what a job downloaded.
+type Report struct {
+	Segments int
+	Bytes    int64
+	Gaps     []hls.Gap `json:",omitempty"`
+}
+
+type Job struct {
+	ID string
+	Request
+
+	// File is what Output expanded to, once the job has started.
+	File string `json:",omitempty"`
+
+	State    State
+	Error    string `json:",omitempty"`
+	Progress dam.Progress
+	Report   *Report `json:",omitempty"`
+
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// DefaultConcurrency is how many downloads a Manager or a Batch runs at once,
+// unless told otherwise.
+const DefaultConcurrency = 2
+
+// concurrency returns n, or DefaultConcurrency if n is not positive.
+func concurrency(n int) int {
+	if n <= 0 {
+		return DefaultConcurrency
+	}
+	return n
+}
+
+// Manager runs jobs, at most Concurrency at a time, keeping them in the
+// StateFile of Dir.
+type Manager struct {
+	Client *http.Client
+	Dir    string
+
+	// Concurrency is how many jobs run at once; zero means
+	// DefaultConcurrency.
+	Concurrency int
+
+	// Logger, if not nil, is where the progress of jobs is logged.
+	Logger *slog.Logger
+
+	// OnEvent, if not nil, returns what the events of the HLS job of uri
+	// are given to, like metrics.Collector.Stream.
+	OnEvent func(uri string) func(hls.Event)
+
+	mu     sync.Mutex
+	jobs   []*Job
+	nextID int
+}
+
+// state is what is stored in the StateFile.
+type state struct {
+	NextID int
+	Jobs   []*Job
+}
+
+func (m *Manager) logger() *slog.Logger {
+	return dam.Logger(m.Logger)
+}
+
+// Load reads the jobs that were kept in Dir, if any, and starts the ones
+// that had not finished.  Jobs that were interrupted start over, except
+// that plain files resume from where they were.  It must be called before
+// anything else.
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(m.Dir, StateFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	m.jobs, m.nextID = s.Jobs, s.NextID
+
+	for _, job := range m.jobs {
+		if job.State == Running {
+			m.logger().Warn("restarting interrupted job", "job", job.ID, "uri", job.URL)
+			job.State = Queued
+		}
+		if job.State == Queued {
+			m.wakeAt(job.StartAt)
+		}
+	}
+	m.scheduleLocked()
+	return nil
+}
+
+// saveLocked replaces the StateFile atomically, so that a crash never leaves
+// half of it behind.
+func (m *Manager) saveLocked() error {
+	data, err := json.MarshalIndent(state{m.nextID, m.jobs}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(m.Dir, StateFile)
+	if err := ioutil.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (m *Manager) save() {
+	if err := m.saveLocked(); err != nil {
+		m.logger().Error("cannot save jobs", "err", err)
+	}
+}
+
+// ErrInvalidRequest is matched, with errors.Is, by the errors of Submit for
+// requests that make no sense.
+var ErrInvalidRequest = errors.New("invalid request")
+
+type invalidRequestError string
+
+func (e invalidRequestError) Error() string        { return string(e) }
+func (e invalidRequestError) Is(target error) bool { return target == ErrInvalidRequest }
+
+// Submit queues a job.
+func (m *Manager) Submit(req Request) (Job, error) {
+	if req.URL == "" {
+		return Job{}, invalidRequestError("no URL given")
+	} else if !filepath.IsLocal(req.Output) {
+		return Job{}, invalidRequestError("output must be a relative path inside the directory of the jobs")
+	} else if !req.StopAt.IsZero() && req.StopAt.Before(req.StartAt) {
+		return Job{}, invalidRequestError("StopAt is before StartAt")
+	}
+	if req.Format == "" {
+		req.Format = "best"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	job := &Job{
+		ID:      strconv.Itoa(m.nextID),
+		Request: req,
+		State:   Queued,
+		Created: time.Now(),
+	}
+	m.jobs = append(m.jobs, job)
+
+	m.wakeAt(job.StartAt)
+	m.scheduleLocked()
+	return *job, nil
+}
+
+// Jobs lists every job, in the order they were submitted.
+func (m *Manager) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, len(m.jobs))
+	for i, job := range m.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+func (m *Manager) findLocked(id string) *Job {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Job returns the job with the given ID.
+func (m *Manager) Job(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job := m.findLocked(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// Cancel stops the job with the given ID, if it has not finished.
+func (m *Manager) Cancel(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.findLocked(id)
+	if job == nil {
+		return Job{}, false
+	}
+
+	switch job.State {
+	case Queued:
+		job.State = Canceled
+		job.Finished = time.Now()
+		m.save()
+	case Running:
+		// the job is finished by its goroutine
+		job.canceled = true
+		job.cancel()
+	}
+	return *job, true
+}
+
+// wakeAt schedules the queued jobs again at t, when a job may start.
+func (m *Manager) wakeAt(t time.Time) {
+	if d := time.Until(t); d > 0 {
+		time.AfterFunc(d, func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.scheduleLocked()
+		})
+	}
+}
+
+// scheduleLocked starts queued jobs that are due, oldest first, for as long
+// as fewer than Concurrency are running.
+func (m *Manager) scheduleLocked() {
+	concurrency := concurrency(m.Concurrency)
+
+	var running int
+	for _, job := range m.jobs {
+		if job.State == Running {
+			running++
+		}
+	}
+
+	now := time.Now()
+	for _, job := range m.jobs {
+		if running >= concurrency {
+			break
+		}
+		if job.State != Queued || job.StartAt.After(now) {
+			continue
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if job.StopAt.IsZero() {
+			ctx, cancel = context.WithCancel(context.Background())
+		} else {
+			ctx, cancel = context.WithDeadline(context.Background(), job.StopAt)
+		}
+		job.State = Running
+		job.Started = now
+		job.cancel = cancel
+		running++
+		go m.run(ctx, job)
+	}
+	m.save()
+}
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	m.logger().Info("starting job", "job", job.ID, "uri", job.URL)
+	report, err := m.download(ctx, job)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.cancel()
+
+	job.Report = report
+	job.Finished = time.Now()
+	// The deadline of ctx is StopAt; stopping there is how a recording of a
+	// live stream succeeds.
+	switch {
+	case job.canceled:
+		job.State = Canceled
+	case err != nil && ctx.Err() != context.DeadlineExceeded:
+		job.State = Failed
+		job.Error = err.Error()
+	default:
+		job.State = Done
+	}
+	m.logger().Info("finished job", "job", job.ID, "uri", job.URL, "state", job.State, "err", err)
+
+	m.scheduleLocked()
+}
+
+func (m *Manager) download(ctx context.Context, job *Job) (*Report, error) {
+	d, format, err := resolve(ctx, m.Client, m.Logger, job.URL, job.Format)
+	if err != nil {
+		return nil, err
+	}
+	output, err := expand(ctx, d, job.URL, format, job.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	job.File = output
+	m.mu.Unlock()
+
+	var onEvent func(hls.Event)
+	if m.OnEvent != nil {
+		onEvent = m.OnEvent(job.URL)
+	}
+
+	filename := filepath.Join(m.Dir, filepath.FromSlash(output))
+	return download(ctx, d, job.URL, format, filename, func(p dam.Progress) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		job.Progress = p
+	}, onEvent)
+}
+
+// SetLogger gives the Downloader d, of any of the protocols, the logger, and
+// returns it.
+func SetLogger(d dam.Downloader, logger *slog.Logger) dam.Downloader {
+	switch c := d.(type) {
+	case *hls.Client:
+		c.Logger = logger
+	case *dash.Client:
+		c.Logger = logger
+	case *smooth.Client:
+		c.Logger = logger
+	case *hds.Client:
+		c.Logger = logger
+	case dam.Progressive:
+		// a copy, unlike the others
+		c.Logger = logger
+		d = c
+	}
+	return d
+}
+
+// resolve finds the Downloader of uri and the format of it that spec
+// selects.
+func resolve(ctx context.Context, client *http.Client, logger *slog.Logger, uri, spec string) (dam.Downloader, dam.Format, error) {
+	d, err := dam.Detect(ctx, client, uri)
+	if errors.Is(err, dam.ErrUnknownProtocol) {
+		d = dam.Progressive{Client: client}
+	} else if err != nil {
+		return nil, dam.Format{}, err
+	}
+	d = SetLogger(d, logger)
+
+	formats, err := d.ListFormats(ctx, uri)
+	if err != nil {
+		return nil, dam.Format{}, err
+	}
+	format, err := dam.SelectFormat(formats, spec)
+	if err != nil {
+		return nil, dam.Format{}, err
+	}
+	return d, format, nil
+}
+
+// expand returns output, expanded for format of uri if it is a template.
+func expand(ctx context.Context, d dam.Downloader, uri string, format dam.Format, output string) (string, error) {
+	if !dam.IsTemplate(output) {
+		return output, nil
+	}
+	fields, err := dam.Describe(ctx, d, uri, format)
+	if err != nil {
+		return "", err
+	}
+	return dam.Template(output).Expand(fields)
+}
+
+// download downloads format of uri, which d is the Downloader of, to
+// filename.  If d is an *hls.Client, its events are also given to onEvent.
+func download(ctx context.Context, d dam.Downloader, uri string, format dam.Format, filename string, progress func(dam.Progress), onEvent func(hls.Event)) (*Report, error) {
+	var done dam.Progress
+	report := func(p dam.Progress) {
+		done = p
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+
+	if p, ok := d.(dam.Progressive); ok {
+		err := p.DownloadFile(ctx, format.URI, filename, report)
+		return &Report{Segments: done.Segments, Bytes: done.Bytes}, err
+	}
+
+	fd, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	hlsClient, ok := d.(*hls.Client)
+	if !ok {
+		err := d.DownloadFormat(ctx, uri, format, fd, report)
+		return &Report{Segments: done.Segments, Bytes: done.Bytes}, err
+	}
+
+	hlsClient.OnEvent = func(ev hls.Event) {
+		if onEvent != nil {
+			onEvent(ev)
+		}
+		if f, ok := ev.(hls.SegmentFinished); ok {
+			report(dam.Progress{Segments: done.Segments + 1, Bytes: done.Bytes + f.Bytes})
+		}
+	}
+	r, err := hlsClient.DownloadReport(ctx, format.URI, fd)
+	return &Report{Segments: r.Segments, Bytes: r.Bytes, Gaps: r.Gaps}, err
+}
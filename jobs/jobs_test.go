@@ -0,0 +1,247 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+	"github.com/otommod/go-dam/dash"
+	"github.com/otommod/go-dam/hds"
+	"github.com/otommod/go-dam/hls"
+	"github.com/otommod/go-dam/metrics"
+	"github.com/otommod/go-dam/smooth"
+)
+
+// waitFor polls the job until it is in the given state.
+func waitFor(t *testing.T, m *Manager, id string, state State) Job {
+	for i := 0; i < 500; i++ {
+		if job, _ := m.Job(id); job.State == state {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job, _ := m.Job(id)
+	t.Fatalf("expected job %s to be %s, found %+v", id, state, job)
+	return job
+}
+
+func TestAPI(t *testing.T) {
+	file := []byte("plain file")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(file)
+	})
+	mux.HandleFunc("/endless.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	media := httptest.NewServer(mux)
+	defer media.Close()
+
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manager{Client: media.Client(), Dir: dir}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+
+	submit := func(req Request) (*http.Response, Job) {
+		body, _ := json.Marshal(req)
+		r, err := http.Post(api.URL+"/jobs", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+
+		var job Job
+		json.NewDecoder(r.Body).Decode(&job)
+		return r, job
+	}
+
+	if r, _ := submit(Request{URL: media.URL + "/file.bin", Output: "../escape.bin"}); r.StatusCode != 400 {
+		t.Errorf("expected an output outside the directory to be refused, found %s", r.Status)
+	}
+
+	r, job := submit(Request{URL: media.URL + "/file.bin", Output: "out/file.bin"})
+	if r.StatusCode != 201 {
+		t.Fatalf("expected %d, found %s", 201, r.Status)
+	}
+	waitFor(t, m, job.ID, Done)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "out/file.bin"))
+	if err != nil || !bytes.Equal(data, file) {
+		t.Errorf("expected %q, found %q (%v)", file, data, err)
+	}
+
+	r, err = http.Get(api.URL + "/jobs/" + job.ID + "/report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	json.NewDecoder(r.Body).Decode(&report)
+	r.Body.Close()
+	if report.Bytes != int64(len(file)) {
+		t.Errorf("expected a report of %d bytes, found %+v", len(file), report)
+	}
+
+	_, endless := submit(Request{URL: media.URL + "/endless.bin", Output: "endless.bin"})
+	waitFor(t, m, endless.ID, Running)
+	r, err = http.Post(api.URL+"/jobs/"+endless.ID+"/cancel", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	waitFor(t, m, endless.ID, Canceled)
+
+	r, err = http.Get(api.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []Job
+	json.NewDecoder(r.Body).Decode(&jobs)
+	r.Body.Close()
+	if len(jobs) != 2 || jobs[0].ID != job.ID || jobs[1].ID != endless.ID {
+		t.Errorf("expected jobs %s and %s, found %+v", job.ID, endless.ID, jobs)
+	}
+}
+
+func TestStopAt(t *testing.T) {
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer media.Close()
+
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manager{Client: media.Client(), Dir: dir}
+	job, err := m.Submit(Request{
+		URL:     media.URL + "/live.bin",
+		Output:  "live.bin",
+		StartAt: time.Now().Add(100 * time.Millisecond),
+		StopAt:  time.Now().Add(300 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != Queued {
+		t.Errorf("expected the job to wait for StartAt, found it %s", job.State)
+	}
+	waitFor(t, m, job.ID, Running)
+	waitFor(t, m, job.ID, Done)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manager{Client: http.DefaultClient, Dir: dir}
+	job, err := m.Submit(Request{
+		URL:     "http://example.com/later.bin",
+		Output:  "later.bin",
+		StartAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &Manager{Client: http.DefaultClient, Dir: dir}
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := restarted.Job(job.ID)
+	if !ok || loaded.State != Queued || loaded.URL != job.URL || !loaded.StartAt.Equal(job.StartAt) {
+		t.Errorf("expected %+v, found %+v", job, loaded)
+	}
+
+	next, err := restarted.Submit(Request{URL: job.URL, Output: "next.bin", StartAt: job.StartAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == job.ID {
+		t.Errorf("expected a new ID, found %s again", next.ID)
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vod.m3u8" {
+			io.WriteString(w, "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\n0.ts\n#EXTINF:4,\n1.ts\n#EXTINF:4,\n2.ts\n#EXT-X-ENDLIST\n")
+			return
+		}
+		io.WriteString(w, "segment")
+	}))
+	defer origin.Close()
+	uri := origin.URL + "/vod.m3u8"
+
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector := metrics.New()
+	m := &Manager{Client: origin.Client(), Dir: dir, OnEvent: collector.Stream}
+	job, err := m.Submit(Request{URL: uri, Output: "vod.ts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, job.ID, Done)
+
+	var buf bytes.Buffer
+	collector.WriteTo(&buf)
+	expected := fmt.Sprintf("dam_segments_downloaded_total{stream=%q} 3\n", uri)
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in\n%s", expected, buf.String())
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	downloaders := []dam.Downloader{&hls.Client{}, &dash.Client{}, &smooth.Client{}, &hds.Client{}, dam.Progressive{}}
+	for _, d := range downloaders {
+		var found *slog.Logger
+		switch c := SetLogger(d, logger).(type) {
+		case *hls.Client:
+			found = c.Logger
+		case *dash.Client:
+			found = c.Logger
+		case *smooth.Client:
+			found = c.Logger
+		case *hds.Client:
+			found = c.Logger
+		case dam.Progressive:
+			found = c.Logger
+		}
+		if found != logger {
+			t.Errorf("%T: expected the logger to be set", d)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package dam
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every record.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discard = slog.New(discardHandler{})
+
+// Logger returns l or, if l is nil, a logger that discards everything, which
+// is what downloads log to unless they are given a logger.
+func Logger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discard
+	}
+	return l
+}
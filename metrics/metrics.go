@@ -0,0 +1,189 @@
+// Package metrics collects metrics of HLS downloads and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/otommod/go-dam/hls"
+)
+
+// buckets are the upper bounds of the histogram of segment fetch latencies,
+// in seconds.
+var buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// stream holds the metrics of the downloads of one stream.
+type stream struct {
+	segments uint64
+	bytes    uint64
+	reloads  uint64
+	expired  uint64
+	retries  uint64
+
+	// latency counts fetches per bucket, the last being +Inf.
+	latency    []uint64
+	latencySum float64
+}
+
+type response struct {
+	host string
+	code int
+}
+
+// Collector collects the metrics of any number of downloads.
+type Collector struct {
+	mu        sync.Mutex
+	streams   map[string]*stream
+	responses map[response]uint64
+}
+
+func New() *Collector {
+	return &Collector{
+		streams:   make(map[string]*stream),
+		responses: make(map[response]uint64),
+	}
+}
+
+// Stream returns a function to set as the OnEvent of an hls.Client, which
+// records its events under the label stream=name.
+func (c *Collector) Stream(name string) func(hls.Event) {
+	return func(ev hls.Event) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		s, ok := c.streams[name]
+		if !ok {
+			s = &stream{latency: make([]uint64, len(buckets)+1)}
+			c.streams[name] = s
+		}
+
+		switch ev := ev.(type) {
+		case hls.PlaylistLoaded:
+			s.reloads++
+		case hls.SegmentFinished:
+			s.segments++
+			s.bytes += uint64(ev.Bytes)
+
+			seconds := ev.Elapsed.Seconds()
+			i := sort.SearchFloat64s(buckets, seconds)
+			s.latency[i]++
+			s.latencySum += seconds
+		case hls.SegmentsExpired:
+			s.expired += ev.Count
+		case hls.Retrying:
+			s.retries++
+		}
+	}
+}
+
+type transport struct {
+	http.RoundTripper
+	c *Collector
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		t.c.mu.Lock()
+		t.c.responses[response{req.URL.Host, r.StatusCode}]++
+		t.c.mu.Unlock()
+	}
+	return r, err
+}
+
+// Transport wraps rt, or http.DefaultTransport if it is nil, so that the
+// status codes of the responses it gets are counted by host.
+func (c *Collector) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return transport{rt, c}
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+
+	names := make([]string, 0, len(c.streams))
+	for name := range c.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counter := func(metric, help string, value func(*stream) uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{stream=\"%s\"} %d\n", metric, escape(name), value(c.streams[name]))
+		}
+	}
+	counter("dam_segments_downloaded_total", "Segments downloaded.",
+		func(s *stream) uint64 { return s.segments })
+	counter("dam_bytes_downloaded_total", "Bytes of segments downloaded.",
+		func(s *stream) uint64 { return s.bytes })
+	counter("dam_playlist_reloads_total", "Times the Media Playlist was loaded.",
+		func(s *stream) uint64 { return s.reloads })
+	counter("dam_segments_expired_total", "Segments that expired before they were downloaded.",
+		func(s *stream) uint64 { return s.expired })
+	counter("dam_retries_total", "Requests that were tried again.",
+		func(s *stream) uint64 { return s.retries })
+
+	const latency = "dam_segment_fetch_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time to fetch and write a segment.\n# TYPE %s histogram\n", latency, latency)
+	for _, name := range names {
+		s := c.streams[name]
+		var count uint64
+		for i, n := range s.latency {
+			count += n
+			le := "+Inf"
+			if i < len(buckets) {
+				le = formatFloat(buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket{stream=\"%s\",le=\"%s\"} %d\n", latency, escape(name), le, count)
+		}
+		fmt.Fprintf(&b, "%s_sum{stream=\"%s\"} %s\n", latency, escape(name), formatFloat(s.latencySum))
+		fmt.Fprintf(&b, "%s_count{stream=\"%s\"} %d\n", latency, escape(name), count)
+	}
+
+	responses := make([]response, 0, len(c.responses))
+	for r := range c.responses {
+		responses = append(responses, r)
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		if responses[i].host != responses[j].host {
+			return responses[i].host < responses[j].host
+		}
+		return responses[i].code < responses[j].code
+	})
+
+	const statuses = "dam_http_responses_total"
+	fmt.Fprintf(&b, "# HELP %s HTTP responses by host and status code.\n# TYPE %s counter\n", statuses, statuses)
+	for _, r := range responses {
+		fmt.Fprintf(&b, "%s{host=\"%s\",code=\"%d\"} %d\n", statuses, escape(r.host), r.code, c.responses[r])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics to Prometheus.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam/hls"
+)
+
+func TestCollector(t *testing.T) {
+	c := New()
+
+	onEvent := c.Stream(`news "live"`)
+	onEvent(hls.PlaylistLoaded{Segments: 3, NewSegments: 3})
+	onEvent(hls.SegmentFinished{Bytes: 1000, Elapsed: 200 * time.Millisecond})
+	onEvent(hls.Retrying{Attempt: 2})
+	onEvent(hls.SegmentFinished{Bytes: 500, Elapsed: 3 * time.Second})
+	onEvent(hls.PlaylistLoaded{Segments: 3, NewSegments: 1})
+	onEvent(hls.SegmentsExpired{From: 4, Count: 2})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: c.Transport(nil)}
+	for _, path := range []string{"/", "/", "/missing"} {
+		r, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+	}
+	u, _ := url.Parse(srv.URL)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		"# TYPE dam_segments_downloaded_total counter\n",
+		`dam_segments_downloaded_total{stream="news \"live\""} 2` + "\n",
+		`dam_bytes_downloaded_total{stream="news \"live\""} 1500` + "\n",
+		`dam_playlist_reloads_total{stream="news \"live\""} 2` + "\n",
+		`dam_segments_expired_total{stream="news \"live\""} 2` + "\n",
+		`dam_retries_total{stream="news \"live\""} 1` + "\n",
+		"# TYPE dam_segment_fetch_seconds histogram\n",
+		`dam_segment_fetch_seconds_bucket{stream="news \"live\"",le="0.1"} 0` + "\n",
+		`dam_segment_fetch_seconds_bucket{stream="news \"live\"",le="0.25"} 1` + "\n",
+		`dam_segment_fetch_seconds_bucket{stream="news \"live\"",le="5"} 2` + "\n",
+		`dam_segment_fetch_seconds_bucket{stream="news \"live\"",le="+Inf"} 2` + "\n",
+		`dam_segment_fetch_seconds_sum{stream="news \"live\""} 3.2` + "\n",
+		`dam_segment_fetch_seconds_count{stream="news \"live\""} 2` + "\n",
+		`dam_http_responses_total{host="` + u.Host + `",code="200"} 2` + "\n",
+		`dam_http_responses_total{host="` + u.Host + `",code="404"} 1` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("expected %q in\n%s", e, body)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package dam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Progressive downloads plain files, like mp4 or zip, splitting them in
+// chunks that are fetched over several connections with Range requests.
+type Progressive struct {
+	Client *http.Client
+
+	// Connections is how many chunks are fetched at once; zero means 4.
+	Connections int
+
+	// ChunkSize is the size of each chunk; zero means 8 MiB.
+	ChunkSize int64
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+}
+
+func (p Progressive) logger() *slog.Logger {
+	return Logger(p.Logger)
+}
+
+// resource is what a Progressive learns about a file before downloading it.
+// Size is -1 if unknown.
+type resource struct {
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+// ifRange returns the validator to send in an If-Range header; § 3.2 of RFC
+// 7233 only allows strong entity-tags there.
+func (r *resource) ifRange() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// changed reports whether resp is for another version of the resource.
+func (r *resource) changed(resp *http.Response) bool {
+	if r.ETag != "" {
+		return resp.Header.Get("ETag") != r.ETag
+	}
+	if r.LastModified != "" {
+		return resp.Header.Get("Last-Modified") != r.LastModified
+	}
+	return false
+}
+
+// parseContentRange parses a Content-Range header like "bytes 0-99/1234".
+// The size is -1 if it is unknown.
+func parseContentRange(contentRange string) (start, end, size int64, err error) {
+	var rangeSpec, sizeSpec string
+	if i := strings.IndexByte(contentRange, '/'); strings.HasPrefix(contentRange, "bytes ") && i > 0 {
+		rangeSpec, sizeSpec = contentRange[len("bytes "):i], contentRange[i+1:]
+	} else {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	size = -1
+	if sizeSpec != "*" {
+		if size, err = strconv.ParseInt(sizeSpec, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+		}
+	}
+
+	if _, err := fmt.Sscanf(rangeSpec, "%d-%d", &start, &end); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return start, end, size, nil
+}
+
+// probe requests the first byte of the file at uri, to learn its size and
+// whether the server supports ranges.  If it does not, the body of the
+// response, which is then the whole file, is returned.
+func (p Progressive) probe(ctx context.Context, uri string) (*resource, io.ReadCloser, error) {
+	var res *resource
+	var body io.ReadCloser
+	err := Retry(ctx, 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return StopRetrying(err)
+		}
+		req.Header.Set("Range", "bytes=0-0")
+
+		r, err := p.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		res = &resource{
+			Size:         -1,
+			ETag:         r.Header.Get("ETag"),
+			LastModified: r.Header.Get("Last-Modified"),
+		}
+
+		switch r.StatusCode {
+		case 200:
+			res.Size = r.ContentLength
+			body = r.Body
+			return nil
+
+		case 206:
+			defer r.Body.Close()
+			_, _, size, err := parseContentRange(r.Header.Get("Content-Range"))
+			if err != nil {
+				return StopRetrying(err)
+			} else if size < 0 {
+				return StopRetrying(errors.New("size of the file is unknown"))
+			}
+			res.Size = size
+			return nil
+		}
+
+		defer r.Body.Close()
+		return RetryStatus(r)
+	})
+	return res, body, err
+}
+
+// fetchRange writes the bytes from start to end, inclusive, of the file to
+// dst.  A failed request is retried from where it stopped.
+func (p Progressive) fetchRange(ctx context.Context, uri string, res *resource, start, end int64, dst io.Writer) error {
+	attempt := func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return StopRetrying(err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if v := res.ifRange(); v != "" {
+			req.Header.Set("If-Range", v)
+		}
+
+		r, err := p.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		// If-Range turns the response into a 200 if the file changed.
+		if r.StatusCode == 200 || (r.StatusCode == 206 && res.changed(r)) {
+			return StopRetrying(ChangedError{URL: uri})
+		} else if r.StatusCode != 206 {
+			return RetryStatus(r)
+		}
+
+		if s, e, _, err := parseContentRange(r.Header.Get("Content-Range")); err != nil {
+			return StopRetrying(err)
+		} else if s != start || e != end {
+			return StopRetrying(fmt.Errorf("expected bytes %d-%d, got %d-%d", start, end, s, e))
+		}
+
+		n, err := io.Copy(dst, r.Body)
+		start += n
+		if err == nil && start <= end {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	// Retry gives up after a while; as long as the attempts make progress,
+	// it is given another while.
+	for {
+		before := start
+		err := Retry(ctx, 90*time.Second, attempt)
+		if err == nil || start == before || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// offsetWriter writes sequentially to an io.WriterAt, starting at off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// Download writes the file at uri to dst.  Chunks are fetched in parallel only
+// if dst is an io.WriterAt, like an *os.File; otherwise they are fetched one
+// after the other.  Use DownloadFile to be able to resume the download.
+func (p Progressive) Download(ctx context.Context, uri string, dst io.Writer) error {
+	return p.download(ctx, uri, dst, nil)
+}
+
+// ListFormats lists the file at uri as a single format.
+func (p Progressive) ListFormats(ctx context.Context, uri string) ([]Format, error) {
+	return []Format{{ID: "0", URI: uri}}, nil
+}
+
+func (p Progressive) DownloadFormat(ctx context.Context, uri string, format Format, dst io.Writer, progress func(Progress)) error {
+	return p.download(ctx, format.URI, dst, progress)
+}
+
+// Describe tells the extension of the file from its URL.
+func (p Progressive) Describe(ctx context.Context, uri string, format Format, fields *Fields) error {
+	if u, err := url.Parse(format.URI); err == nil {
+		fields.Ext = strings.TrimPrefix(path.Ext(u.Path), ".")
+	}
+	return nil
+}
+
+func (p Progressive) download(ctx context.Context, uri string, dst io.Writer, progress func(Progress)) error {
+	res, body, err := p.probe(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		err = copyWhole(dst, res, body, progress)
+	} else {
+		err = p.fetchAll(ctx, uri, res, dst, progress)
+	}
+	if IsClosedPipe(err) {
+		p.logger().Info("output closed, stopping", "uri", uri, "err", err)
+		return nil
+	}
+	return err
+}
+
+// copyWhole writes the body of a response for the whole file to dst.
+func copyWhole(dst io.Writer, res *resource, body io.ReadCloser, progress func(Progress)) error {
+	defer body.Close()
+
+	n, err := io.Copy(dst, body)
+	if err == nil && res.Size >= 0 && n != res.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && progress != nil {
+		progress(Progress{Segments: 1, Bytes: n})
+	}
+	return err
+}
+
+func (p Progressive) fetchAll(ctx context.Context, uri string, res *resource, dst io.Writer, progress func(Progress)) error {
+	chunkSize := p.chunkSize()
+	var starts []int64
+	for start := int64(0); start < res.Size; start += chunkSize {
+		starts = append(starts, start)
+	}
+
+	var done Progress
+	return p.fetchChunks(ctx, uri, res, chunkSize, starts, dst, func(start, n int64) error {
+		done.Segments++
+		done.Bytes += n
+		if progress != nil {
+			progress(done)
+		}
+		return nil
+	})
+}
+
+func (p Progressive) chunkSize() int64 {
+	if p.ChunkSize <= 0 {
+		return 8 << 20
+	}
+	return p.ChunkSize
+}
+
+// fetchChunks writes the chunks of the file beginning at starts to dst, in
+// parallel if dst is an io.WriterAt.  chunkDone is called after each chunk,
+// never concurrently.
+func (p Progressive) fetchChunks(ctx context.Context, uri string, res *resource, chunkSize int64, starts []int64, dst io.Writer, chunkDone func(start, n int64) error) error {
+	connections := p.Connections
+	if connections <= 0 {
+		connections = 4
+	}
+
+	dstAt, ok := dst.(io.WriterAt)
+	if !ok {
+		connections = 1
+	}
+
+	chunkEnd := func(start int64) int64 {
+		if start+chunkSize > res.Size {
+			return res.Size - 1
+		}
+		return start + chunkSize - 1
+	}
+
+	if connections == 1 {
+		for _, start := range starts {
+			end := chunkEnd(start)
+			w := dst
+			if ok {
+				w = &offsetWriter{dstAt, start}
+			}
+			if err := p.fetchRange(ctx, uri, res, start, end, w); err != nil {
+				return err
+			}
+			if err := chunkDone(start, end-start+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	startCh := make(chan int64)
+	g.Go(func() error {
+		defer close(startCh)
+		for _, start := range starts {
+			select {
+			case startCh <- start:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < connections; i++ {
+		g.Go(func() error {
+			for start := range startCh {
+				end := chunkEnd(start)
+				err := p.fetchRange(ctx, uri, res, start, end, &offsetWriter{dstAt, start})
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				err = chunkDone(start, end-start+1)
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,216 @@
+package dam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header           string
+		start, end, size int64
+	}{
+		{"bytes 0-0/1234", 0, 0, 1234},
+		{"bytes 100-199/*", 100, 199, -1},
+	}
+
+	for _, test := range tests {
+		start, end, size, err := parseContentRange(test.header)
+		if err != nil {
+			t.Errorf("%s: %v", test.header, err)
+		} else if start != test.start || end != test.end || size != test.size {
+			t.Errorf("%s: expected %d-%d/%d, found %d-%d/%d", test.header, test.start, test.end, test.size, start, end, size)
+		}
+	}
+
+	for _, header := range []string{"", "bytes */1234", "items 0-1/2"} {
+		if _, _, _, err := parseContentRange(header); err == nil {
+			t.Errorf("%q: expected an error", header)
+		}
+	}
+}
+
+func testFile(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+func TestProgressive(t *testing.T) {
+	content := testFile(10000)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+
+		// fail a request half way through, to be retried from there
+		if n == 3 {
+			var start, end int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+			w.WriteHeader(206)
+			w.Write(content[start : start+10])
+			return
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	fd, err := ioutil.TempFile("", "progressive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	p := Progressive{
+		Client:      srv.Client(),
+		Connections: 3,
+		ChunkSize:   1000,
+	}
+
+	var last Progress
+	err = p.DownloadFormat(context.Background(), srv.URL, Format{URI: srv.URL}, fd, func(pr Progress) {
+		last = pr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadFile(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Error("downloaded file differs")
+	}
+	if last.Segments != 10 || last.Bytes != 10000 {
+		t.Errorf("expected progress of 10 chunks and 10000 bytes, found %+v", last)
+	}
+}
+
+func TestProgressiveSequential(t *testing.T) {
+	content := testFile(2500)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	p := Progressive{
+		Client:    srv.Client(),
+		ChunkSize: 1000,
+	}
+
+	var buf bytes.Buffer
+	if err := p.Download(context.Background(), srv.URL, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Error("downloaded file differs")
+	}
+}
+
+func TestProgressiveWithoutRanges(t *testing.T) {
+	content := testFile(2500)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	p := Progressive{
+		Client: srv.Client(),
+	}
+
+	var buf bytes.Buffer
+	if err := p.Download(context.Background(), srv.URL, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Error("downloaded file differs")
+	}
+}
+
+func TestProgressiveClientErrors(t *testing.T) {
+	content := testFile(2500)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch {
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		case n == 1:
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+		default:
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		}
+	}))
+	defer srv.Close()
+
+	p := Progressive{
+		Client: srv.Client(),
+	}
+
+	// neither the probe nor a range is retried on an error that will not
+	// go away
+	for _, test := range []struct {
+		path     string
+		status   int
+		requests int32
+	}{
+		{"/missing", 404, 1},
+		{"/file.bin", 416, 2},
+	} {
+		atomic.StoreInt32(&requests, 0)
+		var httpErr HTTPError
+		err := p.Download(context.Background(), srv.URL+test.path, ioutil.Discard)
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != test.status {
+			t.Errorf("%s: expected a %d, found %v", test.path, test.status, err)
+		}
+		if requests != test.requests {
+			t.Errorf("%s: expected %d requests, found %d", test.path, test.requests, requests)
+		}
+	}
+}
+
+func TestProgressiveChanged(t *testing.T) {
+	content := testFile(2500)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("ETag", `"v1"`)
+		} else {
+			w.Header().Set("ETag", `"v2"`)
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	p := Progressive{
+		Client:    srv.Client(),
+		ChunkSize: 1000,
+	}
+
+	var buf bytes.Buffer
+	if err := p.Download(context.Background(), srv.URL, &buf); err == nil {
+		t.Error("expected an error for a file that changed")
+	}
+}
@@ -0,0 +1,169 @@
+package dam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// partialFile is what is stored in the sidecar of a file being downloaded,
+// to be able to resume the download.
+type partialFile struct {
+	URL string
+	resource
+	ChunkSize int64
+
+	// Done lists where the chunks already written begin.
+	Done []int64
+}
+
+func sidecarName(filename string) string {
+	return filename + ".dam"
+}
+
+func readSidecar(filename string) (*partialFile, error) {
+	data, err := ioutil.ReadFile(sidecarName(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(partialFile)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveSidecar replaces the sidecar atomically, so that a crash never leaves
+// half of it behind.
+func saveSidecar(filename string, state *partialFile) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := sidecarName(filename) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sidecarName(filename))
+}
+
+// DownloadFile downloads the file at uri to filename, like Download, but it
+// keeps track of the chunks written in a sidecar file next to it, so that an
+// interrupted download can be resumed.  When resuming, the remaining chunks
+// are requested with If-Range; if the file changed on the server since, the
+// download starts over.  Files whose server does not support ranges, or
+// gives neither an ETag nor a Last-Modified, cannot be resumed.
+func (p Progressive) DownloadFile(ctx context.Context, uri, filename string, progress func(Progress)) error {
+	state, err := readSidecar(filename)
+	if err == nil && state.URL == uri && state.ChunkSize > 0 && (state.ETag != "" || state.LastModified != "") {
+		err := p.resume(ctx, filename, state, progress)
+		var changed ChangedError
+		if !errors.As(err, &changed) {
+			return err
+		}
+		p.logger().Warn("file changed on the server; starting over", "uri", uri)
+	}
+
+	res, body, err := p.probe(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Create(filename)
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return err
+	}
+	defer fd.Close()
+
+	if body != nil {
+		os.Remove(sidecarName(filename))
+		return copyWhole(fd, res, body, progress)
+	} else if res.ETag == "" && res.LastModified == "" {
+		// without either, it cannot be told whether the file changed
+		os.Remove(sidecarName(filename))
+		return p.fetchAll(ctx, uri, res, fd, progress)
+	}
+
+	if err := fd.Truncate(res.Size); err != nil {
+		return err
+	}
+
+	state = &partialFile{
+		URL:       uri,
+		resource:  *res,
+		ChunkSize: p.chunkSize(),
+	}
+	if err := saveSidecar(filename, state); err != nil {
+		return err
+	}
+
+	return p.fetchRemaining(ctx, fd, filename, state, progress)
+}
+
+func (p Progressive) resume(ctx context.Context, filename string, state *partialFile, progress func(Progress)) error {
+	fd, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		// the file was deleted, but not its sidecar
+		return ChangedError{URL: state.URL}
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	p.logger().Debug("resuming", "uri", state.URL, "file", filename, "chunks", len(state.Done))
+	return p.fetchRemaining(ctx, fd, filename, state, progress)
+}
+
+// fetchRemaining fetches the chunks not yet written, recording each in the
+// sidecar once it is safely on disk, and removes the sidecar at the end.
+func (p Progressive) fetchRemaining(ctx context.Context, fd *os.File, filename string, state *partialFile, progress func(Progress)) error {
+	done := make(map[int64]bool)
+	var written Progress
+	for _, start := range state.Done {
+		done[start] = true
+		written.Segments++
+		written.Bytes += state.ChunkSize
+		if start+state.ChunkSize > state.Size {
+			written.Bytes -= start + state.ChunkSize - state.Size
+		}
+	}
+
+	var starts []int64
+	for start := int64(0); start < state.Size; start += state.ChunkSize {
+		if !done[start] {
+			starts = append(starts, start)
+		}
+	}
+
+	err := p.fetchChunks(ctx, state.URL, &state.resource, state.ChunkSize, starts, fd, func(start, n int64) error {
+		if err := fd.Sync(); err != nil {
+			return err
+		}
+		state.Done = append(state.Done, start)
+		if err := saveSidecar(filename, state); err != nil {
+			return err
+		}
+
+		written.Segments++
+		written.Bytes += n
+		if progress != nil {
+			progress(written)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Remove(sidecarName(filename))
+}
@@ -0,0 +1,93 @@
+package dam
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloadFileResume(t *testing.T) {
+	content := testFile(3000)
+
+	tests := []struct {
+		name     string
+		etag     string
+		requests []string
+	}{
+		{"unchanged", `"v1"`, []string{"bytes=2000-2999"}},
+		{"changed", `"v0"`, []string{"bytes=2000-2999", "bytes=0-0", "bytes=0-999", "bytes=1000-1999", "bytes=2000-2999"}},
+	}
+
+	for _, test := range tests {
+		var mu sync.Mutex
+		var requests []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.Header.Get("Range"))
+			mu.Unlock()
+
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+		}))
+
+		dir, err := ioutil.TempDir("", "resume")
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(dir, "file.bin")
+
+		// the first two chunks were written before the interruption
+		partial := make([]byte, len(content))
+		copy(partial, content[:2000])
+		if err := ioutil.WriteFile(filename, partial, 0644); err != nil {
+			t.Fatal(err)
+		}
+		err = saveSidecar(filename, &partialFile{
+			URL:       srv.URL,
+			resource:  resource{Size: int64(len(content)), ETag: test.etag},
+			ChunkSize: 1000,
+			Done:      []int64{0, 1000},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := Progressive{
+			Client:      srv.Client(),
+			Connections: 1,
+			ChunkSize:   1000,
+		}
+		if err := p.DownloadFile(context.Background(), srv.URL, filename, nil); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if actual, err := ioutil.ReadFile(filename); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !bytes.Equal(actual, content) {
+			t.Errorf("%s: downloaded file differs", test.name)
+		}
+		if _, err := os.Stat(sidecarName(filename)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the sidecar to be removed", test.name)
+		}
+		if len(requests) != len(test.requests) {
+			t.Errorf("%s: expected requests %v, found %v", test.name, test.requests, requests)
+		} else {
+			for i := range requests {
+				if requests[i] != test.requests[i] {
+					t.Errorf("%s: expected requests %v, found %v", test.name, test.requests, requests)
+					break
+				}
+			}
+		}
+
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
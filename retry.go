@@ -1,6 +1,7 @@
 package dam
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 	"time"
@@ -10,10 +11,30 @@ type stopRetrying struct {
 	error
 }
 
-func parseRetryAfterHeader(retryAfter string) time.Duration {
+func (e stopRetrying) Unwrap() error {
+	return e.error
+}
+
+// StopRetrying wraps err so that Retry returns it without trying again.
+func StopRetrying(err error) error {
+	return stopRetrying{err}
+}
+
+// RetryStatus makes an HTTPError of r, the response to an attempt of Retry,
+// stopping it unless the status of r is one that may go away: 429 or a server
+// error.
+func RetryStatus(r *http.Response) error {
+	err := NewHTTPError(r)
+	if r.StatusCode != http.StatusTooManyRequests && r.StatusCode < 500 {
+		return StopRetrying(err)
+	}
+	return err
+}
+
+func parseRetryAfterHeader(retryAfter string, now time.Time) time.Duration {
 	when, err := http.ParseTime(retryAfter)
-	if err == nil && time.Now().Before(when) {
-		return time.Until(when)
+	if err == nil && now.Before(when) {
+		return when.Sub(now)
 	}
 	after, err := strconv.Atoi(retryAfter)
 	if err == nil && after > 0 {
@@ -22,12 +43,32 @@ func parseRetryAfterHeader(retryAfter string) time.Duration {
 	return 0
 }
 
-func retry(timeout time.Duration, f func() error) (err error) {
-	startedTrying := time.Now()
+// Retry calls f until it succeeds, for as long as timeout.  Between attempts
+// it waits for half a second or, if f failed with a 503 HTTPError, for as long
+// as the Retry-After header asks.  An HTTPError that f returns is given the
+// number of its attempt.
+func Retry(ctx context.Context, timeout time.Duration, f func() error) error {
+	return RetryNotify(ctx, timeout, f, nil)
+}
+
+// RetryNotify is like Retry, but calls notify, if not nil, before every
+// attempt after the first with the number of the attempt and the error of
+// the one before.
+func RetryNotify(ctx context.Context, timeout time.Duration, f func() error, notify func(attempt int, err error)) error {
+	return RetryClock(ctx, RealClock, timeout, f, notify)
+}
 
-	for time.Since(startedTrying) < timeout {
+// RetryClock is like RetryNotify, but times the attempts, and waits between
+// them, on clock.
+func RetryClock(ctx context.Context, clock Clock, timeout time.Duration, f func() error, notify func(attempt int, err error)) (err error) {
+	startedTrying := clock.Now()
+
+	for attempt := 1; clock.Now().Sub(startedTrying) < timeout; attempt++ {
 		delay := time.Second / 2
 
+		if attempt > 1 && notify != nil {
+			notify(attempt, err)
+		}
 		err = f()
 		switch v := err.(type) {
 		case nil:
@@ -35,12 +76,16 @@ func retry(timeout time.Duration, f func() error) (err error) {
 		case stopRetrying:
 			return v.error
 		case HTTPError:
+			v.Attempt = attempt
+			err = v
 			if v.StatusCode == 503 && v.Header.Get("Retry-After") != "" {
-				delay = parseRetryAfterHeader(v.Header.Get("Retry-After"))
+				delay = parseRetryAfterHeader(v.Header.Get("Retry-After"), clock.Now())
 			}
 		}
 
-		time.Sleep(delay)
+		if err := Sleep(ctx, clock, delay); err != nil {
+			return err
+		}
 	}
 	return
 }
@@ -0,0 +1,197 @@
+package smooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/otommod/go-dam"
+)
+
+// defaultTimeScale is the TimeScale of manifests that give none; the unit is
+// then 100ns.
+const defaultTimeScale = 10000000
+
+type Manifest struct {
+	XMLName                xml.Name       `xml:"SmoothStreamingMedia"`
+	MajorVersion           int            `xml:"MajorVersion,attr"`
+	MinorVersion           int            `xml:"MinorVersion,attr"`
+	TimeScale              uint64         `xml:"TimeScale,attr"`
+	Duration               uint64         `xml:"Duration,attr"`
+	IsLive                 bool           `xml:"IsLive,attr"`
+	LookAheadFragmentCount int            `xml:"LookAheadFragmentCount,attr"`
+	DVRWindowLength        uint64         `xml:"DVRWindowLength,attr"`
+	StreamIndexes          []*StreamIndex `xml:"StreamIndex"`
+	Protection             *struct{}      `xml:"Protection"`
+
+	// URL is where the manifest was loaded from, the base of the fragment
+	// URLs.
+	URL *url.URL `xml:"-"`
+}
+
+type StreamIndex struct {
+	Type          string          `xml:"Type,attr"`
+	Subtype       string          `xml:"Subtype,attr"`
+	Name          string          `xml:"Name,attr"`
+	Language      string          `xml:"Language,attr"`
+	URL           string          `xml:"Url,attr"`
+	TimeScale     uint64          `xml:"TimeScale,attr"`
+	Chunks        int             `xml:"Chunks,attr"`
+	MaxWidth      int             `xml:"MaxWidth,attr"`
+	MaxHeight     int             `xml:"MaxHeight,attr"`
+	DisplayWidth  int             `xml:"DisplayWidth,attr"`
+	DisplayHeight int             `xml:"DisplayHeight,attr"`
+	QualityLevels []*QualityLevel `xml:"QualityLevel"`
+	Fragments     []Fragment      `xml:"c"`
+
+	// Manifest is the manifest the StreamIndex is in.
+	Manifest *Manifest `xml:"-"`
+}
+
+type QualityLevel struct {
+	Index            int    `xml:"Index,attr"`
+	Bitrate          uint64 `xml:"Bitrate,attr"`
+	FourCC           string `xml:"FourCC,attr"`
+	MaxWidth         int    `xml:"MaxWidth,attr"`
+	MaxHeight        int    `xml:"MaxHeight,attr"`
+	CodecPrivateData string `xml:"CodecPrivateData,attr"`
+	SamplingRate     int    `xml:"SamplingRate,attr"`
+	Channels         int    `xml:"Channels,attr"`
+	BitsPerSample    int    `xml:"BitsPerSample,attr"`
+	PacketSize       int    `xml:"PacketSize,attr"`
+	AudioTag         int    `xml:"AudioTag,attr"`
+
+	// StreamIndex is the StreamIndex the QualityLevel is in.
+	StreamIndex *StreamIndex `xml:"-"`
+}
+
+// Fragment is a c element.  T is the start time of the fragment; if it is
+// missing, the fragment follows the previous one.  R is the number of
+// consecutive fragments with the same duration, at least one.
+type Fragment struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr"`
+}
+
+// decodeUTF16 converts a document with a UTF-16 byte order mark, as many
+// manifests are, to UTF-8.
+func decodeUTF16(data []byte) []byte {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	default:
+		return data
+	}
+
+	data = data[2:]
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+func parseManifest(r io.Reader, manifestURI string) (*Manifest, error) {
+	manifestURL, err := url.Parse(manifestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(decodeUTF16(data)))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// the document has already been converted from UTF-16
+		if strings.EqualFold(charset, "utf-16") {
+			return input, nil
+		}
+		return nil, dam.NotSupportedError{Feature: "charset " + charset}
+	}
+
+	m := new(Manifest)
+	if err := d.Decode(m); err != nil {
+		return nil, err
+	}
+	m.URL = manifestURL
+
+	if m.Protection != nil {
+		return nil, dam.NotSupportedError{Feature: "protected manifests"}
+	}
+	if m.TimeScale == 0 {
+		m.TimeScale = defaultTimeScale
+	}
+
+	for _, s := range m.StreamIndexes {
+		s.Manifest = m
+		if s.TimeScale == 0 {
+			s.TimeScale = m.TimeScale
+		}
+		for _, q := range s.QualityLevels {
+			q.StreamIndex = s
+		}
+	}
+
+	return m, nil
+}
+
+// chunk is a fragment, with its start time, in units of the TimeScale of its
+// StreamIndex.
+type chunk struct {
+	Time, Duration uint64
+}
+
+// chunks lists the fragments of s.
+func (s *StreamIndex) chunks() []chunk {
+	var chunks []chunk
+	var t uint64
+	for _, f := range s.Fragments {
+		if f.T != nil {
+			t = *f.T
+		}
+		if f.D == 0 {
+			// the duration of the last fragment of a live stream may
+			// not be known yet
+			break
+		}
+
+		repeat := f.R
+		if repeat < 1 {
+			repeat = 1
+		}
+		for i := 0; i < repeat; i++ {
+			chunks = append(chunks, chunk{Time: t, Duration: f.D})
+			t += f.D
+		}
+	}
+	return chunks
+}
+
+// fragmentURL expands the Url template of s, like
+// QualityLevels({bitrate})/Fragments(video={start time}).
+func (s *StreamIndex) fragmentURL(q *QualityLevel, t uint64) (string, error) {
+	ref := strings.NewReplacer(
+		"{bitrate}", fmt.Sprint(q.Bitrate),
+		"{Bitrate}", fmt.Sprint(q.Bitrate),
+		"{start time}", fmt.Sprint(t),
+		"{start_time}", fmt.Sprint(t),
+	).Replace(s.URL)
+
+	u, err := s.Manifest.URL.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,79 @@
+package smooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+const vodManifest = `<?xml version="1.0" encoding="utf-16"?>
+<SmoothStreamingMedia MajorVersion="2" MinorVersion="0" Duration="60000000">
+  <StreamIndex Type="video" Name="video" Chunks="3" Url="QualityLevels({bitrate})/Fragments(video={start time})" MaxWidth="1280" MaxHeight="720">
+    <QualityLevel Index="0" Bitrate="2000000" FourCC="H264" MaxWidth="1280" MaxHeight="720" CodecPrivateData="000000016764001FACD9405005BB011000000300100000030320F1831960000000168EBECB22C"/>
+    <QualityLevel Index="1" Bitrate="500000" FourCC="H264" MaxWidth="640" MaxHeight="360" CodecPrivateData="000000016764001EACD940A02FF97011000003000100000300320F162D96000000168EBECB22C"/>
+    <c t="0" d="20000000" r="2"/>
+    <c d="20000000"/>
+  </StreamIndex>
+  <StreamIndex Type="audio" Name="audio_eng" Language="eng" Chunks="2" Url="QualityLevels({bitrate})/Fragments(audio_eng={start time})">
+    <QualityLevel Index="0" Bitrate="128000" FourCC="AACL" SamplingRate="48000" Channels="2" BitsPerSample="16" PacketSize="4" AudioTag="255" CodecPrivateData="1190"/>
+    <c d="30000000"/>
+    <c d="30000000"/>
+  </StreamIndex>
+</SmoothStreamingMedia>`
+
+// utf16LE encodes s the way IIS serves manifests.
+func utf16LE(s string) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.LittleEndian, u)
+	}
+	return buf.Bytes()
+}
+
+func TestParseManifest(t *testing.T) {
+	m, err := parseManifest(bytes.NewReader(utf16LE(vodManifest)), "http://example.com/vod/video.ism/Manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.TimeScale != defaultTimeScale || m.IsLive {
+		t.Errorf("expected a VOD manifest with the default TimeScale, found TimeScale=%d IsLive=%v", m.TimeScale, m.IsLive)
+	}
+	if len(m.StreamIndexes) != 2 {
+		t.Fatalf("expected 2 StreamIndexes, found %d", len(m.StreamIndexes))
+	}
+
+	video := m.StreamIndexes[0]
+	expected := []chunk{{0, 20000000}, {20000000, 20000000}, {40000000, 20000000}}
+	if actual := video.chunks(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected chunks %v, found %v", expected, actual)
+	}
+
+	audio := m.StreamIndexes[1]
+	expected = []chunk{{0, 30000000}, {30000000, 30000000}}
+	if actual := audio.chunks(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected chunks %v, found %v", expected, actual)
+	}
+
+	u, err := video.fragmentURL(video.QualityLevels[1], 20000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "http://example.com/vod/video.ism/QualityLevels(500000)/Fragments(video=20000000)"; u != expected {
+		t.Errorf("expected fragment URL %s, found %s", expected, u)
+	}
+}
+
+func TestParseProtectedManifest(t *testing.T) {
+	_, err := parseManifest(strings.NewReader(`
+		<SmoothStreamingMedia MajorVersion="2" MinorVersion="0">
+		  <Protection><ProtectionHeader SystemID="9a04f079-9840-4286-ab92-e65be0885f95"/></Protection>
+		</SmoothStreamingMedia>`), "http://example.com/Manifest")
+	if err == nil {
+		t.Error("expected an error for a protected manifest")
+	}
+}
@@ -0,0 +1,398 @@
+package smooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/otommod/go-dam"
+)
+
+// The boxes of ISO/IEC 14496-12 needed to turn the fragments of a
+// QualityLevel, each a moof and an mdat, into a playable fragmented MP4.
+
+// box builds a box out of its payload.
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], typ)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullBox builds a box with a version and flags.
+func fullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	header := u32(uint32(version)<<24 | flags&0xffffff)
+	return box(typ, append([][]byte{header}, payload...)...)
+}
+
+func u16(n uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, n)
+	return b
+}
+
+func u32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func u64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func zeros(n int) []byte {
+	return make([]byte, n)
+}
+
+// unityMatrix is the transformation matrix of mvhd and tkhd that leaves the
+// video as it is.
+var unityMatrix = bytes.Join([][]byte{
+	u32(0x00010000), u32(0), u32(0),
+	u32(0), u32(0x00010000), u32(0),
+	u32(0), u32(0), u32(0x40000000),
+}, nil)
+
+// trackID is the ID of the only track of the files built.
+const trackID = 1
+
+// isVideo reports whether q is a video QualityLevel; anything else is taken
+// to be audio.
+func isVideo(q *QualityLevel) bool {
+	return q.StreamIndex.Type == "video"
+}
+
+// initSegment builds the ftyp and moov boxes of a fragmented MP4 holding q.
+func initSegment(q *QualityLevel) ([]byte, error) {
+	width, height := q.MaxWidth, q.MaxHeight
+	if width == 0 || height == 0 {
+		width, height = q.StreamIndex.MaxWidth, q.StreamIndex.MaxHeight
+	}
+	if width == 0 || height == 0 {
+		width, height = q.StreamIndex.DisplayWidth, q.StreamIndex.DisplayHeight
+	}
+
+	handler, name, header := "soun", "SoundHandler", fullBox("smhd", 0, 0, zeros(4))
+	volume := uint16(0x0100)
+	if isVideo(q) {
+		handler, name, header = "vide", "VideoHandler", fullBox("vmhd", 0, 1, zeros(8))
+		volume = 0
+	} else {
+		width, height = 0, 0
+	}
+
+	entry, err := sampleEntry(q, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	timescale := uint32(q.StreamIndex.TimeScale)
+
+	ftyp := box("ftyp", []byte("iso6"), u32(1), []byte("iso6isom"))
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation and modification time
+		u32(timescale), u32(0),
+		u32(0x00010000), u16(0x0100), zeros(10), // rate, volume and reserved
+		unityMatrix,
+		zeros(24), // pre_defined
+		u32(trackID+1))
+
+	tkhd := fullBox("tkhd", 0, 3, // enabled and in the movie
+		u32(0), u32(0), // creation and modification time
+		u32(trackID), zeros(4),
+		u32(0), zeros(8), // duration and reserved
+		u16(0), u16(0), // layer and alternate group
+		u16(volume), zeros(2),
+		unityMatrix,
+		u32(uint32(width)<<16), u32(uint32(height)<<16))
+
+	mdhd := fullBox("mdhd", 0, 0,
+		u32(0), u32(0), // creation and modification time
+		u32(timescale), u32(0),
+		u16(0x55c4), u16(0)) // undetermined language
+
+	hdlr := fullBox("hdlr", 0, 0,
+		zeros(4), []byte(handler), zeros(12), []byte(name+"\x00"))
+
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), entry),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)))
+
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", header, dinf, stbl)))
+
+	mvex := box("mvex", fullBox("trex", 0, 0,
+		u32(trackID), u32(1), // the track and its sample description
+		u32(0), u32(0), u32(0))) // default duration, size and flags
+
+	return append(ftyp, box("moov", mvhd, trak, mvex)...), nil
+}
+
+func sampleEntry(q *QualityLevel, width, height int) ([]byte, error) {
+	codecPrivateData, err := hex.DecodeString(q.CodecPrivateData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CodecPrivateData: %v", err)
+	}
+
+	switch strings.ToUpper(q.FourCC) {
+	case "H264", "AVC1", "DAVC":
+		avcC, err := avcConfiguration(codecPrivateData)
+		if err != nil {
+			return nil, err
+		}
+
+		compressorName := zeros(32)
+		return box("avc1",
+			zeros(6), u16(1), // reserved and data reference index
+			zeros(16),
+			u16(uint16(width)), u16(uint16(height)),
+			u32(0x00480000), u32(0x00480000), // 72 dpi
+			zeros(4), u16(1), // reserved and frame count
+			compressorName,
+			u16(0x0018), u16(0xffff), // depth and pre_defined
+			box("avcC", avcC)), nil
+
+	case "AACL", "AACH", "MP4A":
+		if len(codecPrivateData) == 0 {
+			codecPrivateData = audioSpecificConfig(q.SamplingRate, q.Channels)
+		}
+
+		bitsPerSample := q.BitsPerSample
+		if bitsPerSample == 0 {
+			bitsPerSample = 16
+		}
+
+		return box("mp4a",
+			zeros(6), u16(1), // reserved and data reference index
+			zeros(8),
+			u16(uint16(q.Channels)), u16(uint16(bitsPerSample)),
+			zeros(4),
+			u32(uint32(q.SamplingRate)<<16),
+			fullBox("esds", 0, 0, esDescriptor(codecPrivateData, q.Bitrate))), nil
+	}
+
+	return nil, dam.NotSupportedError{Feature: "FourCC " + q.FourCC}
+}
+
+// avcConfiguration builds an AVCDecoderConfigurationRecord out of the SPS and
+// PPS in codecPrivateData, which are in Annex B format.
+func avcConfiguration(codecPrivateData []byte) ([]byte, error) {
+	var sps, pps [][]byte
+	for _, nal := range bytes.Split(codecPrivateData, []byte{0, 0, 0, 1}) {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1f {
+		case 7:
+			sps = append(sps, nal)
+		case 8:
+			pps = append(pps, nal)
+		}
+	}
+	if len(sps) == 0 || len(sps[0]) < 4 || len(pps) == 0 {
+		return nil, errors.New("CodecPrivateData lacks an SPS or a PPS")
+	}
+
+	b := []byte{
+		1,                               // configuration version
+		sps[0][1], sps[0][2], sps[0][3], // profile, compatibility and level
+		0xff, // NAL units with 4 byte lengths
+		0xe0 | byte(len(sps)),
+	}
+	for _, nal := range sps {
+		b = append(append(b, u16(uint16(len(nal)))...), nal...)
+	}
+	b = append(b, byte(len(pps)))
+	for _, nal := range pps {
+		b = append(append(b, u16(uint16(len(nal)))...), nal...)
+	}
+	return b, nil
+}
+
+var samplingFrequencies = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// audioSpecificConfig builds the configuration of AAC LC for manifests that
+// give none.
+func audioSpecificConfig(samplingRate, channels int) []byte {
+	index := 15
+	for i, f := range samplingFrequencies {
+		if f == samplingRate {
+			index = i
+		}
+	}
+
+	const aacLC = 2
+	config := uint16(aacLC<<11 | index<<7 | channels<<3)
+	return u16(config)
+}
+
+// descriptor builds a descriptor of ISO/IEC 14496-1.
+func descriptor(tag byte, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+
+	// the length is written in 7 bit groups
+	length := []byte{byte(len(body) & 0x7f)}
+	for n := len(body) >> 7; n > 0; n >>= 7 {
+		length = append([]byte{byte(n&0x7f) | 0x80}, length...)
+	}
+	return append(append([]byte{tag}, length...), body...)
+}
+
+func esDescriptor(audioSpecificConfig []byte, bitrate uint64) []byte {
+	return descriptor(0x03,
+		u16(0), []byte{0}, // ES_ID and flags
+		descriptor(0x04,
+			[]byte{0x40, 0x15}, // MPEG-4 audio stream
+			zeros(3),           // buffer size
+			u32(uint32(bitrate)), u32(uint32(bitrate)),
+			descriptor(0x05, audioSpecificConfig)),
+		descriptor(0x06, []byte{0x02}))
+}
+
+// boxes splits data in the boxes it consists of.
+func boxes(data []byte) ([][]byte, error) {
+	var boxes [][]byte
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data))
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated box")
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+		}
+		if size < 8 || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size of box %q", data[4:8])
+		}
+
+		boxes = append(boxes, data[:size])
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func boxType(b []byte) string {
+	return string(b[4:8])
+}
+
+// rewriteFragment prepares a fragment for being appended to the output: the
+// track ID is changed to that of the moov built by initSegment and, since
+// fragments of Smooth Streaming carry their time only in a uuid box, a tfdt
+// box is added with decodeTime.
+func rewriteFragment(fragment []byte, decodeTime uint64) ([]byte, error) {
+	top, err := boxes(fragment)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	for _, b := range top {
+		if boxType(b) != "moof" {
+			out = append(out, b...)
+			continue
+		}
+
+		moof, err := rewriteMoof(b, decodeTime)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, moof...)
+	}
+	return out, nil
+}
+
+func rewriteMoof(moof []byte, decodeTime uint64) ([]byte, error) {
+	children, err := boxes(moof[8:])
+	if err != nil {
+		return nil, err
+	}
+
+	trafs := make(map[int][][]byte)
+	var added int
+	for i, b := range children {
+		if boxType(b) != "traf" {
+			continue
+		}
+		trafs[i], err = boxes(b[8:])
+		if err != nil {
+			return nil, err
+		}
+
+		var hasTfdt bool
+		for _, c := range trafs[i] {
+			hasTfdt = hasTfdt || boxType(c) == "tfdt"
+		}
+		if !hasTfdt {
+			added += 20
+		}
+	}
+
+	var payload [][]byte
+	for i, b := range children {
+		if boxType(b) != "traf" {
+			payload = append(payload, b)
+			continue
+		}
+
+		var traf [][]byte
+		var hasTfdt bool
+		for _, c := range trafs[i] {
+			hasTfdt = hasTfdt || boxType(c) == "tfdt"
+		}
+
+		for _, c := range trafs[i] {
+			c = append([]byte(nil), c...)
+			switch boxType(c) {
+			case "tfhd":
+				if len(c) < 16 {
+					return nil, errors.New("truncated tfhd")
+				}
+				binary.BigEndian.PutUint32(c[12:], trackID)
+				traf = append(traf, c)
+				if !hasTfdt {
+					traf = append(traf, fullBox("tfdt", 1, 0, u64(decodeTime)))
+				}
+				continue
+
+			case "trun":
+				// The data offset is relative to the start of the moof,
+				// which now has more boxes before the mdat.
+				if len(c) < 20 {
+					return nil, errors.New("truncated trun")
+				}
+				if flags := binary.BigEndian.Uint32(c[8:]) & 0xffffff; flags&0x1 != 0 {
+					offset := int32(binary.BigEndian.Uint32(c[16:]))
+					binary.BigEndian.PutUint32(c[16:], uint32(offset+int32(added)))
+				}
+			}
+			traf = append(traf, c)
+		}
+		payload = append(payload, box("traf", traf...))
+	}
+
+	return box("moof", payload...), nil
+}
@@ -0,0 +1,121 @@
+package smooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// child returns the box of type typ among the boxes in data.
+func child(t *testing.T, data []byte, typ string) []byte {
+	t.Helper()
+
+	children, err := boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range children {
+		if boxType(b) == typ {
+			return b
+		}
+	}
+	t.Fatalf("no %s box", typ)
+	return nil
+}
+
+func TestInitSegment(t *testing.T) {
+	video := &StreamIndex{Type: "video", TimeScale: defaultTimeScale}
+	q := &QualityLevel{
+		FourCC:           "H264",
+		MaxWidth:         1280,
+		MaxHeight:        720,
+		CodecPrivateData: "000000016764001FAC0000000168EBECB22C",
+		StreamIndex:      video,
+	}
+
+	init, err := initSegment(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ftyp := child(t, init, "ftyp"); !bytes.Equal(ftyp[8:12], []byte("iso6")) {
+		t.Errorf("expected brand iso6, found %s", ftyp[8:12])
+	}
+
+	moov := child(t, init, "moov")
+	trak := child(t, moov[8:], "trak")
+	tkhd := child(t, trak[8:], "tkhd")
+	if width, height := binary.BigEndian.Uint32(tkhd[84:]), binary.BigEndian.Uint32(tkhd[88:]); width != 1280<<16 || height != 720<<16 {
+		t.Errorf("expected 1280x720, found %dx%d", width>>16, height>>16)
+	}
+
+	stbl := child(t, child(t, child(t, trak[8:], "mdia")[8:], "minf")[8:], "stbl")
+	stsd := child(t, stbl[8:], "stsd")
+	avc1 := child(t, stsd[16:], "avc1")
+	avcC := child(t, avc1[86:], "avcC")
+	expected := []byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0, 5, 0x67, 0x64, 0x00, 0x1f, 0xac, 1, 0, 5, 0x68, 0xeb, 0xec, 0xb2, 0x2c}
+	if !bytes.Equal(avcC[8:], expected) {
+		t.Errorf("expected avcC\n%x\nfound\n%x", expected, avcC[8:])
+	}
+
+	trex := child(t, child(t, moov[8:], "mvex")[8:], "trex")
+	if id := binary.BigEndian.Uint32(trex[12:]); id != trackID {
+		t.Errorf("expected trex for track %d, found %d", trackID, id)
+	}
+}
+
+func TestAudioInitSegment(t *testing.T) {
+	audio := &StreamIndex{Type: "audio", TimeScale: defaultTimeScale}
+	q := &QualityLevel{
+		FourCC:       "AACL",
+		Bitrate:      128000,
+		SamplingRate: 48000,
+		Channels:     2,
+		StreamIndex:  audio,
+	}
+
+	init, err := initSegment(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without CodecPrivateData, the AudioSpecificConfig of AAC LC is used
+	if !bytes.Contains(init, []byte{0x05, 0x02, 0x11, 0x90}) {
+		t.Error("expected an AudioSpecificConfig of 1190")
+	}
+
+	q.FourCC = "WVC1"
+	if _, err := initSegment(q); err == nil {
+		t.Error("expected an error for an unsupported FourCC")
+	}
+}
+
+func TestRewriteFragment(t *testing.T) {
+	payload := []byte("sample data")
+
+	tfhd := fullBox("tfhd", 0, 0x020000, u32(2))
+	trun := fullBox("trun", 0, 0x000001, u32(1), u32(0))
+	moof := box("moof", fullBox("mfhd", 0, 0, u32(7)), box("traf", tfhd, trun))
+	// the data offset points past the header of the mdat
+	binary.BigEndian.PutUint32(moof[len(moof)-4:], uint32(len(moof)+8))
+
+	fragment := append(moof, box("mdat", payload)...)
+	rewritten, err := rewriteFragment(fragment, 40000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newMoof := child(t, rewritten, "moof")
+	traf := child(t, newMoof[8:], "traf")
+	if id := binary.BigEndian.Uint32(child(t, traf[8:], "tfhd")[12:]); id != trackID {
+		t.Errorf("expected track %d, found %d", trackID, id)
+	}
+	if time := binary.BigEndian.Uint64(child(t, traf[8:], "tfdt")[12:]); time != 40000000 {
+		t.Errorf("expected decode time 40000000, found %d", time)
+	}
+
+	offset := binary.BigEndian.Uint32(child(t, traf[8:], "trun")[16:])
+	if !bytes.HasPrefix(rewritten[offset:], payload) {
+		t.Errorf("expected the data offset to point to the samples, found %d", offset)
+	}
+}
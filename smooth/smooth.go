@@ -0,0 +1,253 @@
+package smooth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func init() {
+	dam.Register(dam.Protocol{
+		Name:         "smooth",
+		Extensions:   []string{".ism", ".isml"},
+		ContentTypes: []string{"application/vnd.ms-sstr+xml"},
+		Sniff: func(prefix []byte) bool {
+			return bytes.Contains(decodeUTF16(prefix), []byte("<SmoothStreamingMedia"))
+		},
+		New: func(client *http.Client) dam.Downloader {
+			return &Client{Client: client}
+		},
+	})
+}
+
+type Client struct {
+	Client *http.Client
+
+	// Logger, if not nil, is where the progress of downloads is logged.
+	Logger *slog.Logger
+
+	// Clock, if not nil, is what the manifest is reloaded and requests retried
+	// by, instead of the real time.
+	Clock dam.Clock
+}
+
+func (c Client) logger() *slog.Logger {
+	return dam.Logger(c.Logger)
+}
+
+func (c Client) clock() dam.Clock {
+	return dam.ClockOrReal(c.Clock)
+}
+
+func (c Client) ReadManifest(ctx context.Context, uri string) (*Manifest, error) {
+	var m *Manifest
+	err := dam.RetryClock(ctx, c.clock(), 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		// add a resonable timeout
+		ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+		defer cancel()
+
+		r, err := c.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if r.StatusCode != 200 {
+			return dam.RetryStatus(r)
+		}
+
+		m, err = parseManifest(r.Body, r.Request.URL.String())
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+		return nil
+	}, nil)
+	return m, err
+}
+
+// formatID identifies a QualityLevel by the name of its StreamIndex and its
+// position in it, like video-2.
+func formatID(q *QualityLevel) string {
+	name := q.StreamIndex.Name
+	if name == "" {
+		name = q.StreamIndex.Type
+	}
+	for i, other := range q.StreamIndex.QualityLevels {
+		if other == q {
+			return fmt.Sprintf("%s-%d", name, i)
+		}
+	}
+	return name
+}
+
+// ListFormats lists the video and audio QualityLevels of the manifest at uri.
+func (c Client) ListFormats(ctx context.Context, uri string) ([]dam.Format, error) {
+	m, err := c.ReadManifest(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var formats []dam.Format
+	for _, s := range m.StreamIndexes {
+		if s.Type != "video" && s.Type != "audio" {
+			continue
+		}
+		for _, q := range s.QualityLevels {
+			formats = append(formats, dam.Format{
+				ID:        formatID(q),
+				URI:       uri,
+				Bandwidth: q.Bitrate,
+				Width:     q.MaxWidth,
+				Height:    q.MaxHeight,
+				Codecs:    q.FourCC,
+			})
+		}
+	}
+	return formats, nil
+}
+
+func (c Client) DownloadFormat(ctx context.Context, uri string, format dam.Format, dst io.Writer, progress func(dam.Progress)) error {
+	return c.download(ctx, uri, format.ID, dst, progress)
+}
+
+// Describe tells the extension of the file that a format is downloaded to;
+// it is always fragmented MP4.
+func (c Client) Describe(ctx context.Context, uri string, format dam.Format, fields *dam.Fields) error {
+	fields.Ext = "mp4"
+	return nil
+}
+
+func (c Client) fetch(ctx context.Context, uri string) ([]byte, error) {
+	var data []byte
+	err := dam.RetryClock(ctx, c.clock(), 90*time.Second, func() error {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return dam.StopRetrying(err)
+		}
+
+		r, err := c.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+
+		if r.StatusCode != 200 {
+			return dam.RetryStatus(r)
+		}
+
+		data, err = ioutil.ReadAll(r.Body)
+		return err
+	}, nil)
+	return data, err
+}
+
+// Download writes the QualityLevel with the given format ID, as listed by
+// ListFormats, to dst as a fragmented MP4.  Live manifests are reloaded until
+// they are no longer live.
+func (c Client) Download(ctx context.Context, uri, formatID string, dst io.Writer) error {
+	return c.download(ctx, uri, formatID, dst, nil)
+}
+
+func (c Client) download(ctx context.Context, uri, id string, dst io.Writer, progress func(dam.Progress)) error {
+	var started bool
+	var nextTime uint64
+	var done dam.Progress
+
+	for {
+		c.logger().Debug("downloading manifest", "uri", uri)
+
+		lastLoadedManifest := c.clock().Now()
+		m, err := c.ReadManifest(ctx, uri)
+		if err != nil {
+			return err
+		}
+
+		var quality *QualityLevel
+		for _, s := range m.StreamIndexes {
+			for _, q := range s.QualityLevels {
+				if formatID(q) == id {
+					quality = q
+				}
+			}
+		}
+		if quality == nil {
+			return fmt.Errorf("QualityLevel %s not found", id)
+		}
+
+		chunks := quality.StreamIndex.chunks()
+		if !started {
+			init, err := initSegment(quality)
+			if err != nil {
+				return err
+			}
+			if _, err := dst.Write(init); err != nil {
+				return err
+			}
+
+			if m.IsLive && len(chunks) > 0 {
+				// begin at the live edge, like players do
+				nextTime = chunks[len(chunks)-1].Time
+			}
+			started = true
+		}
+
+		var chunkDuration time.Duration
+		for _, ch := range chunks {
+			chunkDuration = time.Duration(float64(ch.Duration) / float64(quality.StreamIndex.TimeScale) * float64(time.Second))
+			if ch.Time < nextTime {
+				continue
+			}
+
+			fragmentURL, err := quality.StreamIndex.fragmentURL(quality, ch.Time)
+			if err != nil {
+				return err
+			}
+
+			c.logger().Debug("downloading fragment", "uri", fragmentURL, "time", ch.Time)
+			fragment, err := c.fetch(ctx, fragmentURL)
+			if err != nil {
+				return err
+			}
+			fragment, err = rewriteFragment(fragment, ch.Time)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fragmentURL, err)
+			}
+
+			n, err := dst.Write(fragment)
+			if err != nil {
+				return err
+			}
+
+			nextTime = ch.Time + ch.Duration
+			done.Segments++
+			done.Bytes += int64(n)
+			if progress != nil {
+				progress(done)
+			}
+		}
+
+		if !m.IsLive {
+			return nil
+		}
+
+		wait := chunkDuration
+		if wait <= 0 {
+			wait = 2 * time.Second
+		}
+		if err := dam.Sleep(ctx, c.clock(), lastLoadedManifest.Add(wait).Sub(c.clock().Now())); err != nil {
+			return err
+		}
+	}
+}
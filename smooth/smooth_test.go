@@ -0,0 +1,82 @@
+package smooth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/otommod/go-dam"
+)
+
+func TestDownload(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/video.ism/Manifest", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write(utf16LE(vodManifest))
+	})
+
+	var requested []string
+	mux.HandleFunc("/video.ism/", func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+
+		moof := box("moof", fullBox("mfhd", 0, 0, u32(1)), box("traf", fullBox("tfhd", 0, 0x020000, u32(2))))
+		w.WriteHeader(200)
+		w.Write(append(moof, box("mdat", []byte("samples"))...))
+	})
+
+	c := Client{
+		Client: srv.Client(),
+	}
+
+	formats, err := c.ListFormats(context.Background(), srv.URL+"/video.ism/Manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range formats {
+		ids = append(ids, f.ID)
+	}
+	if len(ids) != 3 || ids[0] != "video-0" || ids[1] != "video-1" || ids[2] != "audio_eng-0" {
+		t.Fatalf("expected formats video-0, video-1 and audio_eng-0, found %v", ids)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Download(context.Background(), srv.URL+"/video.ism/Manifest", "audio_eng-0", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/video.ism/QualityLevels(128000)/Fragments(audio_eng=0)",
+		"/video.ism/QualityLevels(128000)/Fragments(audio_eng=30000000)",
+	}
+	if len(requested) != len(expected) || requested[0] != expected[0] || requested[1] != expected[1] {
+		t.Errorf("expected fragments %v, found %v", expected, requested)
+	}
+
+	top, err := boxes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, b := range top {
+		types = append(types, boxType(b))
+	}
+	if len(types) != 6 || types[0] != "ftyp" || types[1] != "moov" || types[2] != "moof" || types[3] != "mdat" {
+		t.Errorf("expected ftyp, moov and two moof and mdat, found %v", types)
+	}
+
+	// a missing manifest is not tried again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var httpErr dam.HTTPError
+	if _, err := c.ListFormats(ctx, srv.URL+"/missing.ism/Manifest"); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Errorf("expected a 404, found %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package dam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Fields are what a Template is expanded with.
+type Fields struct {
+	URL    string
+	Format Format
+
+	// Title is the title of the presentation, if it has one.
+	Title string
+
+	// Time is when the presentation begins, if it tells, or else when the
+	// download does.
+	Time time.Time
+
+	// Ext is the extension of the file that is written, without the dot.
+	Ext string
+}
+
+// Describer is implemented by Downloaders that can tell more about a format
+// than its Format.
+type Describer interface {
+	// Describe fills in the fields that it knows of format of uri.
+	Describe(ctx context.Context, uri string, format Format, fields *Fields) error
+}
+
+// Describe returns the fields of format of uri, which d is the Downloader
+// of.
+func Describe(ctx context.Context, d Downloader, uri string, format Format) (Fields, error) {
+	fields := Fields{URL: uri, Format: format, Time: time.Now()}
+	if describer, ok := d.(Describer); ok {
+		if err := describer.Describe(ctx, uri, format, &fields); err != nil {
+			return fields, err
+		}
+	}
+	if fields.Ext == "" {
+		fields.Ext = "bin"
+	}
+	return fields, nil
+}
+
+// Template is a name of a file, possibly in directories separated by
+// slashes, with fields in braces, like {host}/{date}/{title}-{height}p.{ext}.
+// The fields are
+//
+//	{host}       the host of the URL
+//	{name}       the last element of the path of the URL, without extension
+//	{title}      the title of the presentation, or else {name}
+//	{id}         the ID of the format
+//	{width}      the width of the format
+//	{height}     the height of the format
+//	{bandwidth}  the bandwidth of the format, in bits per second
+//	{codecs}     the codecs of the format
+//	{date}       the date of Time, like 2006-01-02
+//	{time}       the time of Time, like 15-04-05
+//	{ext}        the extension of the file
+type Template string
+
+// DefaultTemplate names files after their URLs.
+const DefaultTemplate Template = "{name}.{ext}"
+
+// IsTemplate tells whether s has fields to expand.
+func IsTemplate(s string) bool {
+	return strings.Contains(s, "{")
+}
+
+func (f Fields) value(field string) (string, error) {
+	switch field {
+	case "host", "name":
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return "", err
+		}
+		if field == "host" {
+			return u.Hostname(), nil
+		}
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return u.Hostname(), nil
+		}
+		return strings.TrimSuffix(name, path.Ext(name)), nil
+	case "title":
+		if f.Title == "" {
+			return f.value("name")
+		}
+		return f.Title, nil
+	case "id":
+		return f.Format.ID, nil
+	case "width":
+		return strconv.Itoa(f.Format.Width), nil
+	case "height":
+		return strconv.Itoa(f.Format.Height), nil
+	case "bandwidth":
+		return strconv.FormatUint(f.Format.Bandwidth, 10), nil
+	case "codecs":
+		return f.Format.Codecs, nil
+	case "date":
+		return f.Time.Format("2006-01-02"), nil
+	case "time":
+		return f.Time.Format("15-04-05"), nil
+	case "ext":
+		return f.Ext, nil
+	}
+	return "", fmt.Errorf("unknown field {%s} in template", field)
+}
+
+// reservedNames cannot be used as names of files on Windows, whatever their
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// maxNameLength is the longest name of a file that most filesystems allow,
+// in bytes.
+const maxNameLength = 255
+
+// sanitize makes name safe as the name of a file on every filesystem.
+func sanitize(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows drops trailing dots and spaces.
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "_"
+	}
+
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+
+	if len(name) > maxNameLength {
+		// keep the extension, and whole characters
+		ext := path.Ext(name)
+		if len(ext) > maxNameLength/2 {
+			ext = ""
+		}
+		cut := maxNameLength - len(ext)
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + ext
+	}
+	return name
+}
+
+// Expand returns the name of the file that t names for fields, with slashes
+// separating directories.  Each of them, and the name of the file, is
+// sanitized, so that the name is safe on every filesystem and relative.
+func (t Template) Expand(fields Fields) (string, error) {
+	var elements []string
+	for _, element := range strings.Split(string(t), "/") {
+		var b strings.Builder
+		for s := element; s != ""; {
+			i := strings.IndexByte(s, '{')
+			if i < 0 {
+				b.WriteString(s)
+				break
+			}
+			b.WriteString(s[:i])
+
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				return "", fmt.Errorf("unterminated field in template %q", t)
+			}
+			value, err := fields.value(s[i+1 : i+j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			s = s[i+j+1:]
+		}
+
+		if element == "" {
+			// a leading, trailing or doubled slash
+			continue
+		}
+		elements = append(elements, sanitize(b.String()))
+	}
+
+	if len(elements) == 0 {
+		return "", fmt.Errorf("template %q names no file", t)
+	}
+	return strings.Join(elements, "/"), nil
+}
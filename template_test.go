@@ -0,0 +1,57 @@
+package dam
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplate(t *testing.T) {
+	fields := Fields{
+		URL:    "https://example.com/live/channel.m3u8?token=1",
+		Format: Format{ID: "2", Bandwidth: 1280000, Width: 1280, Height: 720, Codecs: "avc1.4d401f,mp4a.40.2"},
+		Title:  "News: 10 O'Clock?",
+		Time:   time.Date(2020, 3, 4, 10, 0, 5, 0, time.UTC),
+		Ext:    "ts",
+	}
+
+	var tests = []struct {
+		template Template
+		expected string
+	}{
+		{"{host}/{date}/{title}-{height}p.{ext}", "example.com/2020-03-04/News_ 10 O'Clock_-720p.ts"},
+		{"{name}.{ext}", "channel.ts"},
+		{"{name}-{id}-{width}x{height}-{bandwidth}.{ext}", "channel-2-1280x720-1280000.ts"},
+		{"/{time}//{codecs}.{ext}", "10-00-05/avc1.4d401f,mp4a.40.2.ts"},
+		{"../{name}", "_/channel"},
+		{"con.{ext}", "_con.ts"},
+		{"{name}. ", "channel"},
+	}
+
+	for _, tt := range tests {
+		found, err := tt.template.Expand(fields)
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+		} else if found != tt.expected {
+			t.Errorf("%s: expected %q, found %q", tt.template, tt.expected, found)
+		}
+	}
+
+	for _, template := range []Template{"{nope}", "{name", "/"} {
+		if found, err := template.Expand(fields); err == nil {
+			t.Errorf("%s: expected an error, found %q", template, found)
+		}
+	}
+
+	fields.Title = ""
+	fields.URL = "https://example.com/"
+	if found, _ := Template("{title}").Expand(fields); found != "example.com" {
+		t.Errorf("expected {title} to fall back to the host, found %q", found)
+	}
+
+	fields.Title = strings.Repeat("é", 200)
+	found, _ := Template("{title}.{ext}").Expand(fields)
+	if len(found) > maxNameLength || !strings.HasSuffix(found, "é.ts") {
+		t.Errorf("expected a name of at most %d bytes ending in %q, found %q", maxNameLength, "é.ts", found)
+	}
+}